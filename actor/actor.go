@@ -0,0 +1,289 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pipeline/dispatcher"
+	"pipeline/jobs"
+)
+
+// ErrStopped actor 已经停止，不再接受消息
+var ErrStopped = errors.New("actor is stopped")
+
+// Actor 按照key串行处理消息，每个key持有独立的状态
+// 同一个key的消息在所属队列中依次执行，处理消息时可以直接读写状态，不需要加锁
+type Actor[K comparable, S any] interface {
+	// 处理消息，返回的错误交给工作队列的 OnError 回调
+	Receive(ctx *Context[K, S], msg any) error
+}
+
+// Activator 可选实现，key 第一次收到消息时初始化状态，例如从存储中加载，默认使用零值
+// 返回错误时不会处理该消息，下一条消息重新激活
+type Activator[K comparable, S any] interface {
+	Activate(ctx context.Context, key K) (S, error)
+}
+
+// Passivator 可选实现，空闲的key释放状态前回调，例如落地
+type Passivator[K comparable, S any] interface {
+	Passivate(ctx context.Context, key K, state S) error
+}
+
+// Context 处理消息的上下文
+type Context[K comparable, S any] struct {
+	context.Context
+
+	key   K
+	state *S
+}
+
+// Key 当前处理消息的key
+func (c *Context[K, S]) Key() K {
+	return c.key
+}
+
+// State 当前key的状态，修改直接生效
+func (c *Context[K, S]) State() *S {
+	return c.state
+}
+
+// 激活的key
+type entry[S any] struct {
+	state S
+	// 最后一次处理消息的时间
+	lastActive atomic.Int64
+}
+
+// System 管理 actor 所有key的状态，消息通过分发器投递到key的队列中串行处理
+// 每个key的状态只在key的队列中创建、读写和释放，不需要额外的锁
+type System[K comparable, S any] struct {
+	actor      Actor[K, S]
+	dispatcher *dispatcher.PipelineDispatcher[K]
+	options    options
+
+	// 激活的key <K, *entry[S]>
+	entries sync.Map
+	// 激活的key数量
+	active atomic.Int64
+	// 已经接受还没有处理完成的消息数，包括正在投递的消息
+	inflight atomic.Int64
+	stopped  atomic.Bool
+
+	// 保护空闲检查的定时器
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// New 创建 actor，定时检查空闲超过 IdleTimeout 的key，在key的队列中释放状态
+// 状态释放后key的任务队列也会空闲，之后和其他空闲队列一样由工作队列定时回收
+func New[K comparable, S any](d *dispatcher.PipelineDispatcher[K], a Actor[K, S], opts ...Option) (*System[K, S], error) {
+	if d == nil || a == nil {
+		return nil, fmt.Errorf("actor dispatcher or actor is nil")
+	}
+
+	o := options{idleTimeout: DefaultIdleTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.idleTimeout <= 0 {
+		return nil, fmt.Errorf("actor idle timeout %v is invalid", o.idleTimeout)
+	}
+
+	s := &System[K, S]{
+		actor:      a,
+		dispatcher: d,
+		options:    o,
+	}
+	s.onTimer()
+
+	return s, nil
+}
+
+// Send 投递消息，在key的队列中串行处理
+func (s *System[K, S]) Send(key K, msg any) error {
+	return s.SendContext(context.Background(), key, msg)
+}
+
+// SendContext 投递携带上下文的消息，上下文在排队期间结束时消息不会被处理
+func (s *System[K, S]) SendContext(ctx context.Context, key K, msg any) error {
+	// 先计数再检查，Stop 等待计数归零后不会再有消息激活key
+	s.inflight.Add(1)
+	if s.stopped.Load() {
+		s.inflight.Add(-1)
+		return ErrStopped
+	}
+
+	err := s.dispatcher.PostContext(ctx, key, func(ctx context.Context) error {
+		defer s.inflight.Add(-1)
+		return s.receive(ctx, key, msg)
+	}, jobs.WithDropHandler(func(err error) {
+		s.inflight.Add(-1)
+	}))
+	if err != nil {
+		s.inflight.Add(-1)
+	}
+
+	return err
+}
+
+// Len 当前激活的key数量
+func (s *System[K, S]) Len() int {
+	return int(s.active.Load())
+}
+
+// Stop 停止接受消息，等待已经接受的消息处理完成后，在每个key的队列中释放状态
+// ctx 结束时返回 ctx 的错误，还没有释放的状态不再回调 Passivate
+func (s *System[K, S]) Stop(ctx context.Context) error {
+	if !s.stopped.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.timer.Stop()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(stopPollInterval)
+	defer ticker.Stop()
+
+	for s.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	var keys []K
+	s.entries.Range(func(key, _ any) bool {
+		keys = append(keys, key.(K))
+		return true
+	})
+
+	var errs []error
+	for _, key := range keys {
+		if err := s.passivateOnQueue(ctx, key, 0); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// 在key的队列中处理消息，第一次收到消息时激活
+func (s *System[K, S]) receive(ctx context.Context, key K, msg any) error {
+	e, err := s.activate(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	defer e.lastActive.Store(time.Now().UnixNano())
+	return s.actor.Receive(&Context[K, S]{Context: ctx, key: key, state: &e.state}, msg)
+}
+
+func (s *System[K, S]) activate(ctx context.Context, key K) (*entry[S], error) {
+	if e, ok := s.entries.Load(key); ok {
+		return e.(*entry[S]), nil
+	}
+
+	e := &entry[S]{}
+	if activator, ok := s.actor.(Activator[K, S]); ok {
+		state, err := activator.Activate(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("actor activate %v error %w", key, err)
+		}
+		e.state = state
+	}
+	e.lastActive.Store(time.Now().UnixNano())
+
+	s.entries.Store(key, e)
+	s.active.Add(1)
+
+	return e, nil
+}
+
+// 释放空闲超过 idle 的key的状态，在key的队列中执行，不会和消息并发
+func (s *System[K, S]) passivate(ctx context.Context, key K, idle time.Duration) error {
+	value, ok := s.entries.Load(key)
+	if !ok {
+		return nil
+	}
+
+	e := value.(*entry[S])
+	if time.Since(time.Unix(0, e.lastActive.Load())) < idle {
+		return nil
+	}
+	s.entries.Delete(key)
+	s.active.Add(-1)
+
+	if passivator, ok := s.actor.(Passivator[K, S]); ok {
+		if err := passivator.Passivate(ctx, key, e.state); err != nil {
+			return fmt.Errorf("actor passivate %v error %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// 投递到key的队列中释放状态并等待完成
+func (s *System[K, S]) passivateOnQueue(ctx context.Context, key K, idle time.Duration) error {
+	var err error
+	if postErr := s.dispatcher.PostAndWait(key, func() {
+		err = s.passivate(ctx, key, idle)
+	}); postErr != nil {
+		return postErr
+	}
+
+	return err
+}
+
+// 定时检查空闲的key，投递到key的队列中释放，检查时收到的消息会刷新活跃时间
+func (s *System[K, S]) onTimer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timer = time.AfterFunc(s.sweepInterval(), func() {
+		s.sweep()
+
+		if !s.stopped.Load() {
+			s.onTimer()
+		}
+	})
+}
+
+func (s *System[K, S]) sweep() {
+	idle := s.options.idleTimeout
+	now := time.Now()
+
+	var keys []K
+	s.entries.Range(func(key, value any) bool {
+		if now.Sub(time.Unix(0, value.(*entry[S]).lastActive.Load())) >= idle {
+			keys = append(keys, key.(K))
+		}
+		return true
+	})
+
+	for _, key := range keys {
+		k := key
+		err := s.dispatcher.PostContext(context.Background(), k, func(ctx context.Context) error {
+			return s.passivate(ctx, k, idle)
+		})
+		if err != nil {
+			// 工作队列已经停止，状态留给 Stop 处理
+			return
+		}
+	}
+}
+
+// 检查间隔为空闲时间的一半
+func (s *System[K, S]) sweepInterval() time.Duration {
+	interval := s.options.idleTimeout / 2
+	if interval < minSweepInterval {
+		return minSweepInterval
+	}
+
+	return interval
+}
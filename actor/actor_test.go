@@ -0,0 +1,141 @@
+package actor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pipeline/dispatcher"
+	"pipeline/jobs"
+	"pipeline/serial"
+)
+
+type counter struct {
+	mu        sync.Mutex
+	store     map[string]int
+	activated int
+}
+
+func (c *counter) Activate(ctx context.Context, key string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.activated++
+	return c.store[key], nil
+}
+
+func (c *counter) Receive(ctx *Context[string, int], msg any) error {
+	delta, ok := msg.(int)
+	if !ok {
+		return errors.New("unknown message")
+	}
+
+	*ctx.State() += delta
+	return nil
+}
+
+func (c *counter) Passivate(ctx context.Context, key string, state int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store[key] = state
+	return nil
+}
+
+func (c *counter) load(key string) (value int, activated int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.store[key], c.activated
+}
+
+func TestActor(t *testing.T) {
+	workQueue, err := jobs.NewWorkQueue(jobs.GetDefaultConfig())
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+	d := dispatcher.NewDispatcher(&serial.DefaultSerializer[string]{}, workQueue)
+	c := &counter{store: map[string]int{"a": 10}}
+
+	system, err := New[string, int](d, c, WithIdleTimeout(time.Millisecond*50))
+	if err != nil {
+		t.Fatalf("new actor error %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		system.Send("a", 1)
+		system.Send("b", 2)
+	}
+	if system.Len() > 2 {
+		t.Fatalf("expected at most %v active keys, got %v", 2, system.Len())
+	}
+
+	// 空闲后释放状态并落地
+	for i := 0; i < 50 && system.Len() != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if a, activated := c.load("a"); a != 110 || activated != 2 {
+		t.Fatalf("expected a %v activated %v, got %v %v", 110, 2, a, activated)
+	}
+	if b, _ := c.load("b"); b != 200 {
+		t.Fatalf("expected b %v, got %v", 200, b)
+	}
+
+	// 再次收到消息时重新激活，Stop 等待已经接受的消息处理完成后释放
+	system.Send("a", 1)
+	if err := system.Stop(context.Background()); err != nil {
+		t.Fatalf("stop actor error %v", err)
+	}
+	if a, activated := c.load("a"); a != 111 || activated != 3 {
+		t.Fatalf("expected a %v activated %v, got %v %v", 111, 3, a, activated)
+	}
+	if err := system.Send("a", 1); err != ErrStopped {
+		t.Fatalf("expected %v, got %v", ErrStopped, err)
+	}
+}
+
+func TestActorStop(t *testing.T) {
+	workQueue, err := jobs.NewWorkQueue(jobs.GetDefaultConfig())
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+	d := dispatcher.NewDispatcher(&serial.DefaultSerializer[string]{}, workQueue)
+	c := &counter{store: map[string]int{}}
+
+	system, err := New[string, int](d, c)
+	if err != nil {
+		t.Fatalf("new actor error %v", err)
+	}
+
+	// 和 Stop 并发的消息，只要投递成功，状态都要在 Stop 返回前释放
+	var sent atomic.Int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if system.Send(fmt.Sprintf("%d-%d", i, j), 1) == nil {
+					sent.Add(1)
+				}
+			}
+		}(i)
+	}
+
+	time.Sleep(time.Millisecond)
+	if err := system.Stop(context.Background()); err != nil {
+		t.Fatalf("stop actor error %v", err)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0
+	for _, value := range c.store {
+		total += value
+	}
+	if total != int(sent.Load()) || system.Len() != 0 {
+		t.Fatalf("expected passivated %v, got %v active %v", sent.Load(), total, system.Len())
+	}
+}
@@ -0,0 +1,24 @@
+package actor
+
+import "time"
+
+const (
+	DefaultIdleTimeout = time.Minute // 默认空闲多久后释放状态
+
+	minSweepInterval = 10 * time.Millisecond // 空闲检查的最小间隔
+	stopPollInterval = time.Millisecond      // 停止时检查消息是否处理完成的间隔
+)
+
+type options struct {
+	idleTimeout time.Duration
+}
+
+// Option actor 配置
+type Option func(o *options)
+
+// WithIdleTimeout 空闲超过该时间的key释放状态，默认一分钟
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = d
+	}
+}
@@ -1,7 +1,9 @@
 package dispatcher
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"pipeline/hash"
 	"pipeline/jobs"
@@ -18,9 +20,9 @@ type PipelineDispatcher[Key any] struct {
 	workerQueue jobs.BaseWorkerQueue
 }
 
-// Post 投递消息
-func (a *PipelineDispatcher[Key]) Post(id Key, f jobs.Job) error {
-	hashvalue, err := a.getHashValue(id)
+// Post 投递消息，通过 jobs.WithTraceContext(ctx) 携带调用方的 span，任务的 span 作为子 span
+func (a *PipelineDispatcher[Key]) Post(id Key, f jobs.Job, opts ...jobs.DispatchOption) error {
+	hashvalue, idBytes, err := a.getHashKey(id)
 	if err != nil {
 		return err
 	}
@@ -30,21 +32,121 @@ func (a *PipelineDispatcher[Key]) Post(id Key, f jobs.Job) error {
 		return fmt.Errorf("worker queue is nil")
 	}
 
-	worker.Dispatch(hashvalue, f)
-	return nil
+	return worker.Dispatch(hashvalue, f, withRawKey(idBytes, opts)...)
+}
+
+// PostTraced 投递消息，自动从 ctx 中获取调用方的 span，任务的 span 作为子 span
+// ctx 只用于传递 span，和 PostContext 不同，排队期间 ctx 结束不会跳过任务
+func (a *PipelineDispatcher[Key]) PostTraced(ctx context.Context, id Key, f jobs.Job, opts ...jobs.DispatchOption) error {
+	return a.Post(id, f, append([]jobs.DispatchOption{jobs.WithTraceContext(ctx)}, opts...)...)
+}
+
+// PostWithPriority 按照优先级投递消息，同一个key中优先级高的消息先执行，同一优先级内先进先出
+func (a *PipelineDispatcher[Key]) PostWithPriority(id Key, priority jobs.Priority, f jobs.Job) error {
+	return a.Post(id, f, jobs.WithPriority(priority))
+}
+
+// PostContext 投递携带上下文的消息，自动从上下文中获取调用方的 span
+// 上下文在排队期间超时或取消时，任务不会被执行
+func (a *PipelineDispatcher[Key]) PostContext(ctx context.Context, id Key, f jobs.ContextJob, opts ...jobs.DispatchOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashvalue, idBytes, err := a.getHashKey(id)
+	if err != nil {
+		return err
+	}
+
+	worker := a.GetWorkQueue()
+	if worker == nil {
+		return fmt.Errorf("worker queue is nil")
+	}
+
+	return worker.DispatchContext(ctx, hashvalue, f, withRawKey(idBytes, opts)...)
+}
+
+// PostAfter 延迟投递消息，到期后进入key的队列，和key的其他消息串行执行
+// 返回的句柄可以在到期前取消
+func (a *PipelineDispatcher[Key]) PostAfter(id Key, delay time.Duration, f jobs.Job, opts ...jobs.DispatchOption) (*jobs.Timer, error) {
+	hashvalue, idBytes, err := a.getHashKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	worker := a.GetWorkQueue()
+	if worker == nil {
+		return nil, fmt.Errorf("worker queue is nil")
+	}
+
+	return worker.DispatchAfter(hashvalue, delay, f, withRawKey(idBytes, opts)...)
+}
+
+// PostAt 在指定时间投递消息，时间已经过去时尽快投递
+func (a *PipelineDispatcher[Key]) PostAt(id Key, at time.Time, f jobs.Job, opts ...jobs.DispatchOption) (*jobs.Timer, error) {
+	return a.PostAfter(id, time.Until(at), f, opts...)
+}
+
+// PostEvery 周期投递消息，和key的其他消息串行执行
+// 上一次的消息还没有执行完成时跳过本次，返回的句柄用于取消
+func (a *PipelineDispatcher[Key]) PostEvery(id Key, interval time.Duration, f jobs.Job, opts ...jobs.DispatchOption) (*jobs.Timer, error) {
+	hashvalue, idBytes, err := a.getHashKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	worker := a.GetWorkQueue()
+	if worker == nil {
+		return nil, fmt.Errorf("worker queue is nil")
+	}
+
+	return worker.DispatchEvery(hashvalue, interval, f, withRawKey(idBytes, opts)...)
+}
+
+// PostAndWait 投递消息并等待执行完成
+// 在同一个队列的任务中重入调用时，直接在当前协程执行
+func (a *PipelineDispatcher[Key]) PostAndWait(id Key, f jobs.Job) error {
+	hashvalue, idBytes, err := a.getHashKey(id)
+	if err != nil {
+		return err
+	}
+
+	worker := a.GetWorkQueue()
+	if worker == nil {
+		return fmt.Errorf("worker queue is nil")
+	}
+
+	return worker.DispatchAndWait(hashvalue, f, jobs.WithRawKey(idBytes))
 }
 
 // 使用的 murmur3 算法，计算 hash value
 func (a *PipelineDispatcher[Key]) getHashValue(id Key) (uint64, error) {
+	hashValue, _, err := a.getHashKey(id)
+	return hashValue, err
+}
+
+// 计算 hash value，同时返回序列化后的原始key
+func (a *PipelineDispatcher[Key]) getHashKey(id Key) (uint64, []byte, error) {
 	idBytes, err := a.serial.Marshal(id)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
-	return hash.DefaultHashFunc(idBytes, 0)
+	hashValue, err := hash.DefaultHashFunc(idBytes, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return hashValue, idBytes, nil
+}
+
+// 携带原始key，精确路由模式下工作队列按照原始key区分队列
+func withRawKey(idBytes []byte, opts []jobs.DispatchOption) []jobs.DispatchOption {
+	return append([]jobs.DispatchOption{jobs.WithRawKey(idBytes)}, opts...)
 }
 
 // GetQueueId 根据hashkey获取job id
+// 精确路由模式下 hash key 冲突的不同key返回相同的id，id 只表示分片
 func (a *PipelineDispatcher[Key]) GetQueueId(id Key) (uint64, error) {
 	hashValue, err := a.getHashValue(id)
 	if err != nil {
@@ -56,7 +158,7 @@ func (a *PipelineDispatcher[Key]) GetQueueId(id Key) (uint64, error) {
 
 // GetJobLen 根据hashkey 获取job 缓冲区长度
 func (a *PipelineDispatcher[Key]) GetJobsBuffLen(id Key) (int, error) {
-	hashValue, err := a.getHashValue(id)
+	hashValue, idBytes, err := a.getHashKey(id)
 	if err != nil {
 		return 0, err
 	}
@@ -66,7 +168,23 @@ func (a *PipelineDispatcher[Key]) GetJobsBuffLen(id Key) (int, error) {
 		return 0, fmt.Errorf("worker queue is nil")
 	}
 
-	return worker.JobsBuffLen(hashValue), nil
+	return worker.JobsBuffLen(hashValue, jobs.WithRawKey(idBytes)), nil
+}
+
+// Resume 恢复因为panic暂停的队列
+func (a *PipelineDispatcher[Key]) Resume(id Key) error {
+	hashValue, idBytes, err := a.getHashKey(id)
+	if err != nil {
+		return err
+	}
+
+	worker := a.GetWorkQueue()
+	if worker == nil {
+		return fmt.Errorf("worker queue is nil")
+	}
+
+	worker.Resume(hashValue, jobs.WithRawKey(idBytes))
+	return nil
 }
 
 func (a *PipelineDispatcher[Key]) GetWorkQueue() jobs.BaseWorkerQueue {
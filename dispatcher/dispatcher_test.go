@@ -1,6 +1,7 @@
 package dispatcher
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"strconv"
@@ -8,6 +9,9 @@ import (
 	"testing"
 	"time"
 
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
 	"pipeline/jobs"
 	"pipeline/serial"
 )
@@ -36,7 +40,7 @@ func TestPipeline(t *testing.T) {
 		},
 	}
 
-	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, jobs.NewWorkQueue(jobs.GetDefaultConfig()))
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, newWorkQueue())
 	for _, c := range cases {
 		t.Run(c.Name, func(t *testing.T) {
 			wg := sync.WaitGroup{}
@@ -72,7 +76,7 @@ func TestPipeline(t *testing.T) {
 
 func TestPipelineFIFO(t *testing.T) {
 
-	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, jobs.NewWorkQueue(jobs.GetDefaultConfig()))
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, newWorkQueue())
 
 	count := 0
 	dispatcher.Post("1", func() {
@@ -142,7 +146,7 @@ func benchmarkPipeline(b *testing.B, keyCount, queueCount int) {
 		Expected int
 	}
 
-	dispatcher := NewDispatcher(&serial.DefaultSerializer[uint64]{}, jobs.NewWorkQueue(jobs.GetDefaultConfig()))
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[uint64]{}, newWorkQueue())
 	for n := 0; n < b.N; n++ {
 		for i := 0; i < keyCount; i++ {
 			wg := sync.WaitGroup{}
@@ -183,3 +187,197 @@ func BenchmarkPipeline100_100(b *testing.B) { benchmarkPipeline(b, 100, 100) }
 func BenchmarkPipeline1000_100(b *testing.B) { benchmarkPipeline(b, 1000, 100) }
 
 func BenchmarkPipeline10000_100(b *testing.B) { benchmarkPipeline(b, 10000, 100) }
+
+func TestPipelinePostContext(t *testing.T) {
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, newWorkQueue())
+
+	// 阻塞住队列，让后续任务在排队期间超时
+	block := make(chan struct{})
+	dispatcher.Post("1", func() {
+		<-block
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	expired := false
+	if err := dispatcher.PostContext(ctx, "1", func(ctx context.Context) error {
+		expired = true
+		return nil
+	}); err != nil {
+		t.Fatalf("post context err %v", err)
+	}
+
+	executed := false
+	if err := dispatcher.PostContext(context.Background(), "1", func(ctx context.Context) error {
+		executed = true
+		return nil
+	}); err != nil {
+		t.Fatalf("post context err %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 10)
+	close(block)
+
+	if err := dispatcher.PostAndWait("1", func() {}); err != nil {
+		t.Fatalf("post and wait err %v", err)
+	}
+
+	if expired {
+		t.Fatalf("expired job should be skipped")
+	}
+	if !executed {
+		t.Fatalf("job should be executed")
+	}
+
+	if err := dispatcher.PostContext(ctx, "1", func(ctx context.Context) error {
+		return nil
+	}); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestPipelinePriority(t *testing.T) {
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, newWorkQueue())
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	dispatcher.Post("1", func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	result := []int{}
+	posts := []struct {
+		Priority jobs.Priority
+		Value    int
+	}{
+		{jobs.PriorityNormal, 1},
+		{jobs.PriorityNormal, 2},
+		{jobs.PriorityHigh, 3},
+		{jobs.PriorityLow, 4},
+		{jobs.PriorityHigh, 5},
+	}
+	for _, p := range posts {
+		value := p.Value
+		dispatcher.PostWithPriority("1", p.Priority, func() {
+			result = append(result, value)
+		})
+	}
+
+	close(block)
+	for {
+		buffLen, _ := dispatcher.GetJobsBuffLen("1")
+		if buffLen == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	dispatcher.PostAndWait("1", func() {})
+
+	if fmt.Sprint(result) != fmt.Sprint([]int{3, 5, 1, 2, 4}) {
+		t.Fatalf("expected %v, got %v", []int{3, 5, 1, 2, 4}, result)
+	}
+}
+
+func TestPipelinePostAfter(t *testing.T) {
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, newWorkQueue())
+
+	mu := sync.Mutex{}
+	result := []int{}
+	record := func(value int) jobs.Job {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			result = append(result, value)
+		}
+	}
+
+	dispatcher.PostAt("1", time.Now().Add(time.Millisecond*60), record(3))
+	dispatcher.PostAfter("1", time.Millisecond*30, record(2))
+	cancelled, _ := dispatcher.PostAfter("1", time.Millisecond*40, record(4))
+	dispatcher.Post("1", record(1))
+
+	if !cancelled.Stop() {
+		t.Fatalf("expected pending timer to be stopped")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	dispatcher.PostAndWait("1", func() {})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(result) != fmt.Sprint([]int{1, 2, 3}) {
+		t.Fatalf("expected %v, got %v", []int{1, 2, 3}, result)
+	}
+	if cancelled.Stop() {
+		t.Fatalf("expected stopped timer not to be stopped again")
+	}
+}
+
+func TestPipelinePostEvery(t *testing.T) {
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, newWorkQueue())
+
+	ticks := make(chan struct{}, 10)
+	timer, err := dispatcher.PostEvery("1", time.Millisecond*10, func() {
+		ticks <- struct{}{}
+	})
+	if err != nil {
+		t.Fatalf("post every error %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		<-ticks
+	}
+	timer.Stop()
+}
+
+func newWorkQueue() jobs.BaseWorkerQueue {
+	workQueue, err := jobs.NewWorkQueue(jobs.GetDefaultConfig())
+	if err != nil {
+		panic(err)
+	}
+
+	return workQueue
+}
+
+func TestPipelineTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	workQueue, err := jobs.NewWorkQueue(jobs.GetDefaultConfig(), jobs.WithTracerProvider(tp))
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, workQueue)
+
+	// 三种投递方式都自动从 ctx 中获取调用方的 span
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	dispatcher.PostTraced(ctx, "1", func() {})
+	dispatcher.PostContext(ctx, "1", func(ctx context.Context) error { return nil })
+	future, err := SubmitContext(ctx, dispatcher, "1", func(ctx context.Context) (string, error) {
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatalf("submit err %v", err)
+	}
+	if result, err := future.Await(context.Background()); err != nil || result != "ok" {
+		t.Fatalf("expected %v, got %v %v", "ok", result, err)
+	}
+	parent.End()
+	workQueue.Shutdown(context.Background())
+
+	traced := 0
+	for _, span := range recorder.Ended() {
+		if span.Name() != "pipeline.job" {
+			continue
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Fatalf("expected parent %v, got %v", parent.SpanContext().SpanID(), span.Parent().SpanID())
+		}
+		traced++
+	}
+	if traced != 3 {
+		t.Fatalf("expected %v traced job spans, got %v", 3, traced)
+	}
+}
@@ -0,0 +1,105 @@
+package dispatcher
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+
+	"pipeline/jobs"
+)
+
+// PanicError 任务执行时发生panic，转换为错误返回给等待方
+type PanicError = jobs.PanicError
+
+// Future 有返回值任务的执行结果，任务在所属key的队列中执行完成后填充
+type Future[R any] struct {
+	done   chan struct{}
+	once   sync.Once
+	result R
+	err    error
+}
+
+func newFuture[R any]() *Future[R] {
+	return &Future[R]{
+		done: make(chan struct{}),
+	}
+}
+
+// 填充执行结果，只有第一次生效
+func (f *Future[R]) complete(result R, err error) {
+	f.once.Do(func() {
+		f.result = result
+		f.err = err
+		close(f.done)
+	})
+}
+
+// Done 任务完成时关闭
+func (f *Future[R]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Await 等待任务执行完成，返回任务的结果，ctx 结束时返回 ctx 的错误
+func (f *Future[R]) Await(ctx context.Context) (R, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		var zero R
+		return zero, ctx.Err()
+	}
+}
+
+// Submit 投递有返回值的任务，通过返回的 Future 获取执行结果
+// 任务中的 panic 会被恢复，以 *PanicError 的形式返回；任务被丢弃时返回丢弃原因
+func Submit[Key, R any](a *PipelineDispatcher[Key], id Key, f func() (R, error)) (*Future[R], error) {
+	future := newFuture[R]()
+	dropped := jobs.WithDropHandler(func(err error) {
+		var zero R
+		future.complete(zero, err)
+	})
+
+	err := a.Post(id, func() {
+		future.run(f)
+	}, dropped)
+	if err != nil {
+		return nil, err
+	}
+
+	return future, nil
+}
+
+// SubmitContext 投递携带上下文的有返回值任务，自动从上下文中获取调用方的 span，任务的 span 作为子 span
+// 上下文在排队期间结束时任务不会执行，Future 返回上下文的错误
+func SubmitContext[Key, R any](ctx context.Context, a *PipelineDispatcher[Key], id Key, f func(ctx context.Context) (R, error)) (*Future[R], error) {
+	future := newFuture[R]()
+	dropped := jobs.WithDropHandler(func(err error) {
+		var zero R
+		future.complete(zero, err)
+	})
+
+	err := a.PostContext(ctx, id, func(ctx context.Context) error {
+		future.run(func() (R, error) {
+			return f(ctx)
+		})
+		return nil
+	}, dropped)
+	if err != nil {
+		return nil, err
+	}
+
+	return future, nil
+}
+
+// 执行任务并填充结果，panic 转换为 *PanicError 后继续抛出，由工作队列的 panic 策略处理
+func (f *Future[R]) run(fn func() (R, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zero R
+			f.complete(zero, &PanicError{Recovered: r, Stack: debug.Stack()})
+			panic(r)
+		}
+	}()
+
+	f.complete(fn())
+}
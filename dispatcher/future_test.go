@@ -0,0 +1,76 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"pipeline/serial"
+)
+
+func TestSubmit(t *testing.T) {
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, newWorkQueue())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	count := 0
+	future, err := Submit(dispatcher, "1", func() (int, error) {
+		count++
+		return count, nil
+	})
+	if err != nil {
+		t.Fatalf("submit err %v", err)
+	}
+
+	result, err := future.Await(ctx)
+	if err != nil || result != 1 {
+		t.Fatalf("expected %v, got %v err %v", 1, result, err)
+	}
+
+	errTest := errors.New("test error")
+	errFuture, err := Submit(dispatcher, "1", func() (string, error) {
+		return "", errTest
+	})
+	if err != nil {
+		t.Fatalf("submit err %v", err)
+	}
+	if _, err := errFuture.Await(ctx); err != errTest {
+		t.Fatalf("expected %v, got %v", errTest, err)
+	}
+
+	panicFuture, err := Submit(dispatcher, "1", func() (string, error) {
+		panic("this is a test panic")
+	})
+	if err != nil {
+		t.Fatalf("submit err %v", err)
+	}
+
+	var panicErr *PanicError
+	if _, err := panicFuture.Await(ctx); !errors.As(err, &panicErr) {
+		t.Fatalf("expected panic error, got %v", err)
+	}
+}
+
+func TestFutureAwaitTimeout(t *testing.T) {
+	dispatcher := NewDispatcher(&serial.DefaultSerializer[string]{}, newWorkQueue())
+
+	block := make(chan struct{})
+	defer close(block)
+
+	future, err := Submit(dispatcher, "1", func() (int, error) {
+		<-block
+		return 1, nil
+	})
+	if err != nil {
+		t.Fatalf("submit err %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	if _, err := future.Await(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
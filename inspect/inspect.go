@@ -0,0 +1,98 @@
+package inspect
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pipeline/jobs"
+	"pipeline/logger"
+)
+
+const (
+	// DefaultPath Register 注册的路径
+	DefaultPath = "/debug/pipeline"
+	// DefaultLimit 默认最多展示的key数量
+	DefaultLimit = 100
+)
+
+// Source 提供快照的工作队列，jobs.BaseWorkerQueue 和 pipeline.Pipeline 都实现了该接口
+type Source interface {
+	Snapshot() *jobs.Snapshot
+}
+
+// Handler 返回展示工作队列快照的 http.Handler
+// 默认返回 HTML 页面，?format=json 或者 Accept: application/json 时返回 JSON
+// ?limit=N 限制展示的key数量，默认 DefaultLimit，0 表示不限制，输出失败时记录到 logger.Default()
+func Handler(source Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := DefaultLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit "+value, http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		snapshot := source.Snapshot()
+		truncated := limit > 0 && len(snapshot.Keys) > limit
+		if truncated {
+			snapshot.Keys = snapshot.Keys[:limit]
+		}
+
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(snapshot); err != nil {
+				logger.Default().Warn("inspect encode snapshot error", "error", err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(w, struct {
+			*jobs.Snapshot
+			Truncated bool
+		}{snapshot, truncated}); err != nil {
+			logger.Default().Warn("inspect render snapshot error", "error", err)
+		}
+	})
+}
+
+// Register 在 mux 的 DefaultPath 上注册，mux 为 nil 时注册到 http.DefaultServeMux
+func Register(mux *http.ServeMux, source Source) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+
+	mux.Handle(DefaultPath, Handler(source))
+}
+
+var page = template.Must(template.New("pipeline").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pipeline</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+</style>
+</head>
+<body>
+<h2>pipeline {{if .Stopped}}(stopped){{end}}</h2>
+<p>{{.Time.Format "2006-01-02 15:04:05.000"}}</p>
+<p>pool cap {{.Pool.Cap}} running {{.Pool.Running}} waiting {{.Pool.Waiting}}, active keys {{len .Keys}}{{if .Truncated}}+{{end}}, backlog {{.Backlog}}</p>
+<table>
+<tr><th>key</th><th>raw key</th><th>class</th><th>backlog</th><th>oldest job age</th><th>running</th><th>running for</th><th>paused</th></tr>
+{{range .Keys}}<tr><td>{{.Key}}</td><td>{{printf "%q" .RawKey}}</td><td>{{.Class}}</td><td>{{.Backlog}}</td><td>{{.OldestJobAge}}</td><td>{{.Running}}</td><td>{{.RunningFor}}</td><td>{{.Paused}}</td></tr>
+{{end}}</table>
+{{if .Truncated}}<p>truncated, use ?limit=0 to show all keys</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,89 @@
+package inspect
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pipeline/jobs"
+)
+
+func TestHandler(t *testing.T) {
+	cfg := jobs.GetDefaultConfig()
+	cfg.PanicPolicy = jobs.PanicPause
+	cfg.OnPanic = func(key uint64, recovered any, stack []byte) {}
+	workQueue, err := jobs.NewWorkQueue(cfg)
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+	for key := uint64(1); key <= 3; key++ {
+		workQueue.Dispatch(key, func() { <-block })
+		workQueue.Dispatch(key, func() {})
+	}
+
+	// key 4 panic 后暂停，积压一个任务
+	workQueue.Dispatch(4, func() { panic("this is a test panic") })
+	workQueue.Dispatch(4, func() {})
+	for workQueue.JobsBuffLen(4) != 1 || workQueue.Snapshot().Pool.Running != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Millisecond * 5)
+
+	mux := http.NewServeMux()
+	Register(mux, workQueue)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	snapshot := getSnapshot(t, server.URL+DefaultPath+"?format=json&limit=2")
+	if len(snapshot.Keys) != 2 || snapshot.Backlog != 4 || snapshot.Pool.Running != 3 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	// limit=0 返回所有的key，每个key的等待时间和暂停状态
+	all := getSnapshot(t, server.URL+DefaultPath+"?format=json&limit=0")
+	if len(all.Keys) != 4 {
+		t.Fatalf("expected %v keys, got %+v", 4, all.Keys)
+	}
+	for _, key := range all.Keys {
+		if key.Backlog != 1 || key.OldestJobAge <= 0 {
+			t.Fatalf("expected backlog and oldest job age of key %v, got %+v", key.Key, key)
+		}
+		if key.Paused != (key.Key == 4) || key.Running == (key.Key == 4) {
+			t.Fatalf("unexpected state of key %v, got %+v", key.Key, key)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	Handler(workQueue).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, DefaultPath, nil))
+	body := recorder.Body.String()
+	if !strings.Contains(recorder.Header().Get("Content-Type"), "text/html") || !strings.Contains(body, "running 3") {
+		t.Fatalf("unexpected html %v", body)
+	}
+
+	recorder = httptest.NewRecorder()
+	Handler(workQueue).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, DefaultPath+"?limit=x", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected %v, got %v", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func getSnapshot(t *testing.T, url string) *jobs.Snapshot {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get snapshot error %v", err)
+	}
+	defer resp.Body.Close()
+
+	snapshot := &jobs.Snapshot{}
+	if err := json.NewDecoder(resp.Body).Decode(snapshot); err != nil {
+		t.Fatalf("decode snapshot error %v", err)
+	}
+
+	return snapshot
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+
+	"pipeline/dispatcher"
+	"pipeline/jobs"
+	"pipeline/serial"
+)
+
+// Pipeline 独立的 pipeline 实例，拥有自己的工作队列，和其他实例互不影响
+type Pipeline struct {
+	workerQueue jobs.BaseWorkerQueue
+
+	// uint64 key 的分发器
+	uint64Dispatcher *dispatcher.PipelineDispatcher[uint64]
+	// bytes key 的分发器
+	bytesDispatcher *dispatcher.PipelineDispatcher[[]byte]
+}
+
+type options struct {
+	config        *jobs.PipelineConfig
+	workerOptions []jobs.WorkerOption
+}
+
+// Option pipeline 实例配置
+type Option func(o *options)
+
+// WithConfig 工作队列的配置，默认使用 jobs.GetDefaultConfig
+func WithConfig(cfg *jobs.PipelineConfig) Option {
+	return func(o *options) {
+		o.config = cfg
+	}
+}
+
+// WithWorkerOptions 工作队列的创建选项，例如消费池的回收间隔、预分配
+func WithWorkerOptions(opts ...jobs.WorkerOption) Option {
+	return func(o *options) {
+		o.workerOptions = append(o.workerOptions, opts...)
+	}
+}
+
+// New 创建独立的 pipeline 实例，不再使用时调用 Shutdown 或者 Stop 释放工作队列
+func New(opts ...Option) (*Pipeline, error) {
+	o := options{config: jobs.GetDefaultConfig()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	workerQueue, err := jobs.NewWorkQueue(o.config, o.workerOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pipeline{
+		workerQueue:      workerQueue,
+		uint64Dispatcher: dispatcher.NewDispatcher(&serial.Uint64Serializer{}, workerQueue),
+		bytesDispatcher:  dispatcher.NewDispatcher(&serial.ByteSerializer{}, workerQueue),
+	}, nil
+}
+
+// NewDispatcher 创建使用该实例工作队列的分发器，支持任意类型的key
+func NewDispatcher[Key any](p *Pipeline, s dispatcher.Serializer[Key]) *dispatcher.PipelineDispatcher[Key] {
+	return dispatcher.NewDispatcher(s, p.workerQueue)
+}
+
+// WorkerQueue 实例的工作队列
+func (p *Pipeline) WorkerQueue() jobs.BaseWorkerQueue {
+	return p.workerQueue
+}
+
+// Uint64 uint64 key 的分发器
+func (p *Pipeline) Uint64() *dispatcher.PipelineDispatcher[uint64] {
+	return p.uint64Dispatcher
+}
+
+// Bytes bytes key 的分发器
+func (p *Pipeline) Bytes() *dispatcher.PipelineDispatcher[[]byte] {
+	return p.bytesDispatcher
+}
+
+// Reconfigure 在线调整配置，不丢弃已经投递的任务
+func (p *Pipeline) Reconfigure(cfg *jobs.PipelineConfig) error {
+	return p.workerQueue.Reconfigure(cfg)
+}
+
+// Shutdown 停止接受投递，等待所有任务执行完成
+func (p *Pipeline) Shutdown(ctx context.Context) (*jobs.ShutdownReport, error) {
+	return p.workerQueue.Shutdown(ctx)
+}
+
+// Stop 停止工作队列，丢弃所有未执行的任务
+func (p *Pipeline) Stop() {
+	p.workerQueue.Stop()
+}
+
+// Snapshot 工作队列的快照
+func (p *Pipeline) Snapshot() *jobs.Snapshot {
+	return p.workerQueue.Snapshot()
+}
+
+// StuckKeys 执行时间超过阈值的队列
+func (p *Pipeline) StuckKeys() []jobs.StuckKey {
+	return p.workerQueue.StuckKeys()
+}
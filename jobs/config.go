@@ -1,5 +1,15 @@
 package jobs
 
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 // PipelineConfig pipeline 自定义配置
 type PipelineConfig struct {
 	// 最大工作池大小，默认1000
@@ -7,6 +17,98 @@ type PipelineConfig struct {
 	// 每个worker最多处理的任务数，默认10
 	// 每个任务队列和worker协程会进行提交绑定，防止任务队列长时间占有worker协程，每次处理一批Job后，将退出绑定，重新提交
 	MaxJobsPerWorker int32 `yaml:"max_jobs_per_worker"`
+	// 每个worker每批次最多占用的时间，默认0不限制
+	// 超过后让出协程重新提交，开启后每批次的任务数按照任务的平均耗时自适应调整，不超过 MaxJobsPerWorker
+	MaxTimePerWorker time.Duration `yaml:"max_time_per_worker"`
+	// 每个key最多缓存的任务数，默认0不限制
+	MaxJobsPerKey int32 `yaml:"max_jobs_per_key"`
+	// 缓存的任务数达到 MaxJobsPerKey 时的处理策略，默认阻塞等待
+	OverflowPolicy OverflowPolicy `yaml:"overflow_policy"`
+	// 任务panic后的处理策略，默认继续执行后续任务
+	PanicPolicy PanicPolicy `yaml:"panic_policy"`
+	// 慢任务阈值，任务执行超过该时间会被标记为卡住，默认0不检查
+	SlowJobThreshold time.Duration `yaml:"slow_job_threshold"`
+	// 精确路由，按照原始key区分任务队列，hash key 冲突的不同key不会被串行化在一起，默认关闭
+	// 开启后需要通过 WithRawKey 携带原始key，dispatcher 会自动携带
+	ExactKeyRouting bool `yaml:"exact_key_routing"`
+	// 任务队列按照 hash key 分片存储，每个分片独立加锁，默认0使用64个分片
+	ProviderShards int32 `yaml:"provider_shards"`
+	// 任务队列的实现，默认分块的环形队列，在线调整只对新建的队列生效
+	QueueType QueueType `yaml:"queue_type"`
+	// 公平调度的分类权重，key 通过 WithClass 指定分类，默认为空不开启公平调度
+	// 开启后消费协程按照权重分配给各个分类，没有配置的分类使用 DefaultClass 的权重，默认为1
+	ClassWeights map[string]int `yaml:"class_weights"`
+
+	// 任务panic回调，默认打印日志
+	OnPanic func(key uint64, recovered any, stack []byte) `yaml:"-"`
+	// ContextJob 返回错误回调，默认打印日志
+	OnError func(key uint64, err error) `yaml:"-"`
+}
+
+// OverflowPolicy 任务队列溢出策略
+type OverflowPolicy string
+
+const (
+	OverflowBlock      OverflowPolicy = "block"       // 阻塞等待队列有空位
+	OverflowReject     OverflowPolicy = "reject"      // 拒绝投递，返回 ErrQueueFull
+	OverflowDropOldest OverflowPolicy = "drop_oldest" // 丢弃优先级最低的队列中最早的任务
+	OverflowDropNewest OverflowPolicy = "drop_newest" // 丢弃当前投递的任务
+)
+
+// QueueType 任务队列的实现
+type QueueType string
+
+const (
+	QueueRing QueueType = "ring" // 分块的环形队列
+	QueueList QueueType = "list" // 基于 container/list 的队列
+)
+
+// PanicPolicy 任务panic后的处理策略
+type PanicPolicy string
+
+const (
+	PanicContinue    PanicPolicy = "continue" // 继续执行后续任务
+	PanicPause       PanicPolicy = "pause"    // 暂停该key的队列，调用 Resume 后继续执行
+	PanicDropBacklog PanicPolicy = "drop"     // 丢弃该key队列中剩余的任务
+)
+
+// Validate 检查配置是否合法
+func (c *PipelineConfig) Validate() error {
+	if c == nil ||
+		c.MaxWorkerQueueCount <= 0 ||
+		c.MaxJobsPerWorker <= 0 ||
+		c.MaxTimePerWorker < 0 ||
+		c.MaxJobsPerKey < 0 ||
+		c.SlowJobThreshold < 0 ||
+		c.ProviderShards < 0 {
+		return fmt.Errorf("config is invalid %+v ", c)
+	}
+
+	switch c.OverflowPolicy {
+	case "", OverflowBlock, OverflowReject, OverflowDropOldest, OverflowDropNewest:
+	default:
+		return fmt.Errorf("config overflow policy %q is invalid", c.OverflowPolicy)
+	}
+
+	for class, weight := range c.ClassWeights {
+		if weight <= 0 {
+			return fmt.Errorf("config class %q weight %d is invalid", class, weight)
+		}
+	}
+
+	switch c.QueueType {
+	case "", QueueRing, QueueList:
+	default:
+		return fmt.Errorf("config queue type %q is invalid", c.QueueType)
+	}
+
+	switch c.PanicPolicy {
+	case "", PanicContinue, PanicPause, PanicDropBacklog:
+	default:
+		return fmt.Errorf("config panic policy %q is invalid", c.PanicPolicy)
+	}
+
+	return nil
 }
 
 // GetDefaultConfig  pipeline 默认数值
@@ -14,10 +116,78 @@ func GetDefaultConfig() *PipelineConfig {
 	return &PipelineConfig{
 		MaxWorkerQueueCount: DefaultMaxWorkerCount,
 		MaxJobsPerWorker:    DefaultMaxJobsPerWorker,
+		OverflowPolicy:      OverflowBlock,
+		PanicPolicy:         PanicContinue,
+		ProviderShards:      DefaultProviderShards,
+		QueueType:           QueueRing,
 	}
 }
 
+// LoadConfig 加载配置，在默认配置上依次覆盖配置文件和环境变量，最后检查配置是否合法
+// 配置文件支持 YAML 和 JSON，path 为空时不读取文件
+// 环境变量为 ConfigEnvPrefix 加上大写的 yaml 字段名，例如 PIPELINE_MAX_WORKER_QUEUE_COUNT=100，值按照 YAML 解析
+func LoadConfig(path string) (*PipelineConfig, error) {
+	cfg := GetDefaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// JSON 是 YAML 的子集，使用同一个解析，时间可以写成 "1s" 的格式
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("config %s unmarshal error %w", path, err)
+		}
+	}
+
+	if err := cfg.loadEnv(ConfigEnvPrefix); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// 使用环境变量覆盖配置
+func (c *PipelineConfig) loadEnv(prefix string) error {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag.Get("yaml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name := prefix + strings.ToUpper(tag)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if err := yaml.Unmarshal([]byte(value), v.Field(i).Addr().Interface()); err != nil {
+			return fmt.Errorf("config env %s unmarshal error %w", name, err)
+		}
+	}
+
+	return nil
+}
+
 const (
+	// ConfigEnvPrefix LoadConfig 读取的环境变量前缀
+	ConfigEnvPrefix = "PIPELINE_"
+
 	DefaultMaxWorkerCount   = 1000 // 最大工作队列
 	DefaultMaxJobsPerWorker = 10   // 每个worker最多处理的任务数
+	DefaultProviderShards   = 64   // 任务队列分片数量
+
+	shutdownPollInterval = 10 * time.Millisecond // 停止时检查队列是否执行完成的间隔
+	minWatchdogInterval  = 10 * time.Millisecond // 慢任务检查的最小间隔
+	maxWatchdogInterval  = time.Second           // 慢任务检查的最大间隔
+	jobCostDecay         = 8                     // 任务平均耗时的衰减系数，新样本占 1/jobCostDecay
+	timerTick            = 10 * time.Millisecond // 时间轮每个槽的时间跨度，延迟任务的精度
+	timerSlots           = 512                   // 时间轮的槽数
+	submitRetryInterval  = 10 * time.Millisecond // 非阻塞的消费池已满时重试提交的间隔
 )
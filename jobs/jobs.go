@@ -1,29 +1,80 @@
 package jobs
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"log"
+	"github.com/panjf2000/ants/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	"pipeline/logger"
 	"pipeline/metrics"
 	"pipeline/serial"
-	"github.com/panjf2000/ants/v2"
 )
 
 // GlobalWorkerQueueGetter 全局工作队列回调
 var GlobalWorkerQueueGetter func() BaseWorkerQueue
 
+var (
+	// ErrQueueFull 任务队列已满，拒绝投递
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrJobDropped 任务队列已满，任务被丢弃
+	ErrJobDropped = errors.New("job dropped")
+	// ErrStopped 工作队列已经停止，不再接受投递
+	ErrStopped = errors.New("worker queue is stopped")
+	// ErrPanicDropped 任务panic后按照 PanicDropBacklog 策略丢弃了队列中剩余的任务
+	ErrPanicDropped = errors.New("job dropped after panic")
+
+	// 任务队列已经被空闲回收，需要重新获取队列投递
+	errRetired = errors.New("job queue is retired")
+)
+
+// PanicError 任务执行时发生panic，转换为错误返回给等待方
+type PanicError struct {
+	Recovered any
+	Stack     []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("job panic: %v", e.Recovered)
+}
+
 // job 回调函数
 type Job func()
 
 // worker queue
 type BaseWorkerQueue interface {
 	// 消息派发，消费
-	Dispatch(key uint64, f Job)
+	Dispatch(key uint64, f Job, opts ...DispatchOption) error
+	// 消息派发，等待消费完成
+	DispatchAndWait(key uint64, f Job, opts ...DispatchOption) error
+	// 携带上下文的消息派发
+	DispatchContext(ctx context.Context, key uint64, f ContextJob, opts ...DispatchOption) error
+	// 延迟消息派发，到期后进入key的队列
+	DispatchAfter(key uint64, delay time.Duration, f Job, opts ...DispatchOption) (*Timer, error)
+	// 周期消息派发，上一次的消息还没有执行完成时跳过本次
+	DispatchEvery(key uint64, interval time.Duration, f Job, opts ...DispatchOption) (*Timer, error)
 	// 获取当前jobs缓冲区长度
-	JobsBuffLen(key uint64) int
-	// 停止
+	JobsBuffLen(key uint64, opts ...DispatchOption) int
+	// 获取执行时间超过阈值的队列
+	StuckKeys() []StuckKey
+	// 获取工作队列的快照
+	Snapshot() *Snapshot
+	// 停止，丢弃所有未执行的任务
 	Stop()
+	// 停止接受投递，等待所有任务执行完成
+	Shutdown(ctx context.Context) (*ShutdownReport, error)
+	// 在线调整配置，不丢弃、不打乱已经投递的任务
+	Reconfigure(cfg *PipelineConfig) error
+	// 恢复因为panic暂停的队列
+	Resume(key uint64, opts ...DispatchOption)
 }
 
 type BaseWorker interface {
@@ -31,94 +82,381 @@ type BaseWorker interface {
 	ConsumerPool() *ants.Pool
 	// 每批次处理的最多任务数
 	MaxJobsPerWorker() int32
+	// 每批次最多占用的时间，0表示不限制
+	MaxTimePerWorker() time.Duration
+	// 每个key最多缓存的任务数
+	MaxJobsPerKey() int32
+	// 任务数超过上限时的处理策略
+	OverflowPolicy() OverflowPolicy
+	// 任务panic处理，返回后续的处理策略
+	HandlePanic(key uint64, recovered any, stack []byte) PanicPolicy
+	// ContextJob 返回的错误处理
+	HandleError(key uint64, err error)
+	// 交给公平调度器提交，没有开启公平调度时返回 false
+	Schedule(j *JobQueue, class string) bool
+	// 日志
+	Logger() logger.Logger
+	// 任务 span 使用的 tracer
+	Tracer() trace.Tracer
 }
 
 // JobQueue 任务队列
 type JobQueue struct {
-	// 任务队列
-	jobs *Queue
+	// 队列的hash key
+	key uint64
+	// 精确路由模式下的原始key
+	rawKey string
+	// 公平调度的分类
+	class string
+	// 上报指标使用的hash key
+	hashkey string
+	// 任务队列，按照优先级分级
+	jobs *priorityTaskQueue
 	// 是否需要提交
 	needSubmit bool
+	// 是否因为panic暂停消费
+	paused bool
+	// 工作队列停止后关闭，不再接受投递
+	closed bool
+	// 空闲回收后从生产池中移除，不再接受投递
+	retired bool
+	// 正在消费任务的协程id，0表示没有在消费
+	running atomic.Uint64
+	// 当前任务开始执行的时间，0表示没有任务在执行
+	runningSince atomic.Int64
+	// 任务的平均耗时，只在消费协程中读写
+	jobCost time.Duration
 	// 全局锁
 	sync.Mutex
+	// 队列有空位时通知阻塞的投递方
+	notFull *sync.Cond
 
 	// 注入接口
 	BaseWorker
 }
 
-func (j *JobQueue) equeue(f Job) (isNeedSubmit bool) {
+func newJobQueue(key uint64, rawKey string, queueType QueueType, worker BaseWorker) *JobQueue {
+	queue := &JobQueue{
+		key:        key,
+		rawKey:     rawKey,
+		hashkey:    strconv.FormatUint(key, 10),
+		jobs:       newPriorityTaskQueue(queueType),
+		needSubmit: true,
+		BaseWorker: worker,
+	}
+	queue.notFull = sync.NewCond(&queue.Mutex)
+
+	return queue
+}
+
+// 入队，队列已满时按照溢出策略处理，返回被丢弃的任务
+func (j *JobQueue) equeue(t *task) (isNeedSubmit bool, dropped []*task, err error) {
 	j.Lock()
 	defer j.Unlock()
 
-	j.jobs.Enqueue(f)
+	if j.closed {
+		return false, nil, ErrStopped
+	}
+	// 获取队列后被空闲回收，投递到这里的任务不会再被获取，由调用方重新获取队列
+	if j.retired {
+		return false, nil, errRetired
+	}
+
+	// 是否在自己队列的任务中投递，只在第一次需要阻塞时检查，阻塞期间不会改变
+	checked := false
+	for limit := int(j.MaxJobsPerKey()); limit > 0 && j.jobs.Size() >= limit; {
+		switch policy := j.OverflowPolicy(); policy {
+		case OverflowReject:
+			metrics.ReportJobOverflow(j.key, string(policy))
+			return false, nil, ErrQueueFull
+		case OverflowDropNewest:
+			metrics.ReportJobOverflow(j.key, string(policy))
+			return false, []*task{t}, nil
+		case OverflowDropOldest:
+			metrics.ReportJobOverflow(j.key, string(policy))
+			dropped = append(dropped, j.jobs.DequeueLowest())
+		default:
+			// 在自己队列的任务中阻塞等待，永远等不到空位
+			if !checked && j.isRunningOnCurrent() {
+				metrics.ReportJobOverflow(j.key, string(OverflowReject))
+				return false, nil, ErrQueueFull
+			}
+			checked = true
+			j.notFull.Wait()
+			// 工作队列停止时被唤醒，队列已经清空，不能再入队
+			if j.closed {
+				return false, dropped, ErrStopped
+			}
+		}
+	}
+
+	t.enqueuedAt = time.Now()
+	if t.class != "" {
+		j.class = t.class
+	}
+	j.jobs.Enqueue(t)
 	// 首次投递，提交任务
-	if j.needSubmit {
+	if j.needSubmit && !j.paused {
 		j.needSubmit = false
-		return true
+		return true, dropped, nil
 	}
 
-	return false
+	return false, dropped, nil
 }
 
-func (j *JobQueue) dequeue() Job {
+func (j *JobQueue) dequeue() *task {
 	j.Lock()
 	defer j.Unlock()
-	f := j.jobs.Dequeue()
-	if f != nil {
-		return f.(Job)
+	t := j.jobs.Dequeue()
+	if t != nil {
+		j.notFull.Signal()
+	}
+
+	return t
+}
+
+func (j *JobQueue) post(t *task) error {
+	isNeedSubmit, dropped, err := j.equeue(t)
+	for _, d := range dropped {
+		d.drop(ErrJobDropped)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if isNeedSubmit {
+		j.submitTaskBlocking()
 	}
 
 	return nil
 }
 
 // Post 投递任务
-func (j *JobQueue) Post(f Job) {
-	if j.equeue(f) {
-		j.submitTaskBlocking()
+func (j *JobQueue) Post(f Job, opts ...DispatchOption) error {
+	return j.post(newTask(f, opts))
+}
+
+// PostContext 投递携带上下文的任务，执行前上下文已经结束的任务会被跳过
+func (j *JobQueue) PostContext(ctx context.Context, f ContextJob, opts ...DispatchOption) error {
+	return j.post(newContextTask(ctx, f, opts))
+}
+
+// PostAndWait 投递任务并等待任务执行完成，任务 panic 时返回 *PanicError
+// 如果在该队列正在执行的任务中再次调用（重入），直接在当前协程执行，避免互相等待产生死锁
+// 重入执行时 panic 同样转换为 *PanicError 返回，不会中断外层的任务
+func (j *JobQueue) PostAndWait(f Job) error {
+	if j.isRunningOnCurrent() {
+		return runRecovered(f)
+	}
+
+	done := make(chan error, 1)
+	err := j.Post(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- &PanicError{Recovered: r, Stack: debug.Stack()}
+				// 继续抛出，由工作队列的 panic 策略处理
+				panic(r)
+			}
+			done <- nil
+		}()
+		f()
+	}, WithDropHandler(func(err error) {
+		done <- err
+	}))
+	if err != nil {
+		return err
 	}
+
+	return <-done
+}
+
+// 直接执行任务，panic 转换为 *PanicError
+func runRecovered(f Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Recovered: r, Stack: debug.Stack()}
+		}
+	}()
+
+	f()
+	return nil
+}
+
+// 当前协程是否正在消费该队列的任务，只有队列正在消费时才需要获取当前协程的id
+// 消费协程的id每批次获取一次，没有其他方式区分重入的调用方和其他协程的投递
+func (j *JobQueue) isRunningOnCurrent() bool {
+	running := j.running.Load()
+	return running != 0 && running == serial.GoroutineID()
 }
 
 func (j *JobQueue) needRetrySubmit() (isNeedSubmit bool) {
 	j.Lock()
 	defer j.Unlock()
 
-	if j.jobs.Size() > 0 {
+	if j.jobs.Size() > 0 && !j.paused {
 		// 继续关闭提交开关，返回给调用方立即提交
 		j.needSubmit = false
 		return true
 	} else {
-		// 任务队列为空，打开需要提交的开关，等待下次Post来的请求触发提交
+		// 任务队列为空或者暂停，打开需要提交的开关，等待下次Post或者Resume来的请求触发提交
 		j.needSubmit = true
 		return false
 	}
 }
 
 func (j *JobQueue) doJobs() {
+	goid := serial.GoroutineID()
+	j.running.Store(goid)
+
 	defer func() {
 		// 如果队列中又来了任务，继续提交，这时，post来的job已经跳过了检查提交
 		if j.needRetrySubmit() {
-			go serial.RecoverGo(j.submitTaskBlocking)
+			go serial.RecoverGoWith(j.Logger(), j.submitTaskBlocking)
 		}
 	}()
+	// 先于重新提交执行，只清除自己的标记
+	defer j.running.CompareAndSwap(goid, 0)
 
-	for i := int32(0); i < j.MaxJobsPerWorker(); i++ {
-		f := j.dequeue()
-		if f == nil {
+	budget := j.MaxTimePerWorker()
+	start := time.Now()
+	for i, n := int32(0), j.batchSize(budget); i < n; i++ {
+		t := j.dequeue()
+		if t == nil {
 			break
 		}
-		f()
+
+		begin := time.Now()
+		policy, panicked := j.runTask(t, i)
+		j.observeJobCost(time.Since(begin))
+
+		if panicked {
+			switch policy {
+			case PanicPause:
+				j.Logger().Warn("job queue paused after panic", "key", j.key, "queue_size", j.Size())
+				j.pause()
+				return
+			case PanicDropBacklog:
+				for _, d := range j.clear() {
+					d.drop(ErrPanicDropped)
+				}
+			}
+		}
+
+		// 超过时间预算，让出协程重新提交
+		if budget > 0 && time.Since(start) >= budget {
+			break
+		}
+	}
+}
+
+// 每批次处理的任务数，开启时间预算后按照任务的平均耗时调整，至少处理一个任务
+func (j *JobQueue) batchSize(budget time.Duration) int32 {
+	limit := j.MaxJobsPerWorker()
+	if budget <= 0 || j.jobCost <= 0 {
+		return limit
+	}
+
+	n := budget / j.jobCost
+	if n < 1 {
+		return 1
+	}
+	if n < time.Duration(limit) {
+		return int32(n)
+	}
+
+	return limit
+}
+
+// 更新任务的平均耗时
+func (j *JobQueue) observeJobCost(cost time.Duration) {
+	if j.jobCost == 0 {
+		j.jobCost = cost
+		return
+	}
+
+	j.jobCost += (cost - j.jobCost) / jobCostDecay
+}
+
+// 执行单个任务，panic 不会影响同一批次的其他任务，position 为任务在当前批次中的位置
+func (j *JobQueue) runTask(t *task, position int32) (policy PanicPolicy, panicked bool) {
+	j.runningSince.Store(time.Now().UnixNano())
+	defer j.runningSince.Store(0)
+
+	span := j.startSpan(t, position)
+	defer span.End()
+	// ContextJob 中创建的 span 挂在任务的 span 下
+	if t.ctx != nil {
+		t.ctx = trace.ContextWithSpan(t.ctx, span)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			recordSpanPanic(span, r)
+			policy = j.HandlePanic(j.key, r, debug.Stack())
+		}
+	}()
+
+	if err := t.run(j.key, j.hashkey); err != nil {
+		recordSpanError(span, err)
+		j.HandleError(j.key, err)
+	}
+
+	return
+}
+
+func (j *JobQueue) pause() {
+	j.Lock()
+	defer j.Unlock()
+	j.paused = true
+}
+
+// Resume 恢复因为panic暂停的队列，继续消费积压的任务
+func (j *JobQueue) Resume() {
+	j.Lock()
+	if !j.paused {
+		j.Unlock()
+		return
+	}
+
+	j.paused = false
+	isNeedSubmit := j.needSubmit && j.jobs.Size() > 0
+	if isNeedSubmit {
+		j.needSubmit = false
+	}
+	j.Unlock()
+
+	if isNeedSubmit {
+		j.submitTaskBlocking()
 	}
 }
 
 func (j *JobQueue) submitTaskBlocking() {
+	// 开启公平调度时由调度器提交
+	if j.Schedule(j, j.className()) {
+		return
+	}
+
+	j.submitToPool()
+}
+
+// 提交到消费池，消费池满时阻塞
+func (j *JobQueue) submitToPool() {
 	metrics.ReportPoolSize(int64(j.ConsumerPool().Running()), int64(j.ConsumerPool().Waiting()))
 
 	now := time.Now()
 	if err := j.ConsumerPool().Submit(j.doJobs); err != nil {
-		log.Printf("job queue submit error %v pool %d", err, j.ConsumerPool().Running())
+		pool := j.ConsumerPool()
+		j.Logger().Error("job queue submit error", "key", j.key, "error", err, "queue_size", j.Size(),
+			"pool_running", pool.Running(), "pool_waiting", pool.Waiting(), "pool_cap", pool.Cap())
+		// 非阻塞的消费池已满，稍后重试，任务队列保持绑定状态，不会重复提交
+		if errors.Is(err, ants.ErrPoolOverload) {
+			time.AfterFunc(submitRetryInterval, j.submitToPool)
+		}
 	}
 
-	metrics.ReportSubmitConsume(time.Since(now).Milliseconds())
+	metrics.ReportSubmitConsume(int64(time.Since(now)))
 }
 
 func (j *JobQueue) Size() int {
@@ -127,49 +465,292 @@ func (j *JobQueue) Size() int {
 	return j.jobs.Size()
 }
 
+func (j *JobQueue) className() string {
+	j.Lock()
+	defer j.Unlock()
+	return j.class
+}
+
+// IsIdle 队列为空，并且没有绑定消费协程，暂停的队列需要保留暂停状态，不是空闲
 func (j *JobQueue) IsIdle() bool {
 	j.Lock()
 	defer j.Unlock()
-	return j.jobs.Size() == 0 && !j.needSubmit
+	return j.isIdle()
+}
+
+func (j *JobQueue) isIdle() bool {
+	return j.jobs.Size() == 0 && j.needSubmit && !j.paused
+}
+
+// 空闲时标记为已回收，和入队在同一个锁中检查，回收后的投递返回 errRetired
+func (j *JobQueue) retire() bool {
+	j.Lock()
+	defer j.Unlock()
+
+	if !j.isIdle() {
+		return false
+	}
+
+	j.retired = true
+	return true
+}
+
+// 队列没有绑定消费协程，并且为空或者因为panic暂停，暂停的队列不会再有任务执行
+func (j *JobQueue) isSettled() bool {
+	j.Lock()
+	defer j.Unlock()
+	return j.needSubmit && (j.jobs.Size() == 0 || j.paused)
+}
+
+// 清空队列，返回被清除的任务
+func (j *JobQueue) clear() []*task {
+	j.Lock()
+	defer j.Unlock()
+
+	tasks := make([]*task, 0, j.jobs.Size())
+	for t := j.jobs.Dequeue(); t != nil; t = j.jobs.Dequeue() {
+		tasks = append(tasks, t)
+	}
+	j.notFull.Broadcast()
+
+	return tasks
+}
+
+// 停止时关闭因为panic暂停的队列，唤醒阻塞在已满队列上的投递方返回 ErrStopped，积压的任务保留到停止时统计
+func (j *JobQueue) closeIfPaused() {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.paused && !j.closed {
+		j.closed = true
+		j.notFull.Broadcast()
+	}
+}
+
+// 关闭队列，唤醒阻塞的投递方返回 ErrStopped，返回被清除的任务
+func (j *JobQueue) close() []*task {
+	j.Lock()
+	j.closed = true
+	j.Unlock()
+
+	return j.clear()
 }
 
 // WorkerQueue	工作队列
 type WorkerQueue struct {
-	cfg     *PipelineConfig
-	stopped bool // 是否停止
+	cfg     atomic.Pointer[PipelineConfig]
+	stopped atomic.Bool  // 是否停止
+	posting atomic.Int64 // 正在投递的请求数
 
 	// 消费池
 	consumer *ants.Pool
+	// 消费池的创建选项
+	options workerOptions
+	// 任务 span 使用的 tracer
+	tracer trace.Tracer
+	// 公平调度器
+	scheduler *fairScheduler
+	// 延迟任务的时间轮
+	timers *timingWheel
 
 	// 生产池
-	provider      map[uint64]*JobQueue // <hashkey, *JobQueue>
-	providerMutex sync.Mutex
+	provider *providerRegistry
+
+	// 执行时间超过阈值的队列
+	stuck      map[*JobQueue]StuckKey
+	stuckMutex sync.Mutex
+}
+
+// ShutdownReport 停止时未执行的任务统计
+type ShutdownReport struct {
+	// 每个key未执行的任务数 <hashkey, count>
+	Pending map[uint64]int
+}
+
+// PendingJobs 未执行的任务总数
+func (r *ShutdownReport) PendingJobs() int {
+	total := 0
+	for _, count := range r.Pending {
+		total += count
+	}
+
+	return total
 }
 
 func (w *WorkerQueue) start() (err error) {
-	w.consumer, err = ants.NewPool(int(w.cfg.MaxWorkerQueueCount),
-		ants.WithPreAlloc(true),
-		ants.WithNonblocking(false))
+	w.consumer, err = ants.NewPool(int(w.config().MaxWorkerQueueCount), w.options.antsOptions()...)
 	if err != nil {
 		return err
 	}
 
+	// 全局的 tracer provider 在设置前会代理到之后设置的实现，可以直接缓存
+	tracerProvider := w.options.tracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	w.tracer = tracerProvider.Tracer(TracerName)
+
+	w.scheduler = newFairScheduler(w.classWeight, w.hasClassWeight)
+	w.timers = newTimingWheel(timerTick, timerSlots)
 	w.onTimer()
+	w.onWatchdog()
+
+	w.Logger().Debug("worker queue started", "pool_cap", w.consumer.Cap(), "max_jobs_per_worker", w.config().MaxJobsPerWorker)
 	return
 }
 
-// Stop 停止工作队列
+// Stop 停止工作队列，未执行的任务直接丢弃
 func (w *WorkerQueue) Stop() {
+	w.stopped.Store(true)
+	report := w.stopTimers()
+	w.dropPending(report)
+	w.scheduler.close()
+	w.consumer.Release()
+
+	w.Logger().Debug("worker queue stopped", "pending", report.PendingJobs())
+}
+
+// Reconfigure 在线调整配置
+// 消费池大小通过 ants Tune 调整，已经投递的任务保留在原有队列中，每个key的执行顺序不变
+func (w *WorkerQueue) Reconfigure(cfg *PipelineConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if w.stopped.Load() {
+		return ErrStopped
+	}
+
+	// 切换路由模式会把同一个key的任务拆分到两个队列，打乱执行顺序
+	if cfg.ExactKeyRouting != w.config().ExactKeyRouting {
+		return fmt.Errorf("config exact key routing can not be changed online")
+	}
+
+	// 预分配的消费池不支持 Tune 调整大小
+	if w.options.preAlloc && cfg.MaxWorkerQueueCount != w.config().MaxWorkerQueueCount {
+		return fmt.Errorf("config max worker queue count can not be changed online with pre-alloc pool")
+	}
+
+	// 分片数量在创建时确定
+	if providerShardCount(cfg.ProviderShards) != int32(len(w.provider.shards)) {
+		return fmt.Errorf("config provider shards can not be changed online")
+	}
+
+	newCfg := *cfg
+	w.cfg.Store(&newCfg)
+	w.consumer.Tune(int(newCfg.MaxWorkerQueueCount))
+	w.Logger().Debug("worker queue reconfigured", "pool_cap", w.consumer.Cap(), "max_jobs_per_worker", newCfg.MaxJobsPerWorker)
+
+	// 队列上限可能调大，唤醒阻塞的投递方重新检查
+	w.provider.each(func(queue *JobQueue) bool {
+		queue.notFull.Broadcast()
+		return true
+	})
+
+	return nil
+}
+
+func (w *WorkerQueue) config() *PipelineConfig {
+	return w.cfg.Load()
+}
+
+// Shutdown 停止接受新的投递，等待所有key的队列按顺序执行完成
+// 还没有到期的延迟任务直接丢弃，因为panic暂停的队列不再等待，积压的任务直接丢弃
+// ctx 结束时丢弃剩余的任务，返回未执行的任务统计和 ctx 的错误
+func (w *WorkerQueue) Shutdown(ctx context.Context) (*ShutdownReport, error) {
+	w.stopped.Store(true)
+	report := w.stopTimers()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for !w.drained() {
+		select {
+		case <-ctx.Done():
+			w.dropPending(report)
+			w.scheduler.close()
+			w.consumer.Release()
+			w.Logger().Warn("worker queue shutdown timeout", "pending", report.PendingJobs(), "error", ctx.Err())
+			return report, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	// 只剩暂停队列中积压的任务
+	w.dropPending(report)
+	w.scheduler.close()
 	w.consumer.Release()
-	w.provider = make(map[uint64]*JobQueue)
-	w.stopped = true
+	w.Logger().Debug("worker queue shutdown", "pending", report.PendingJobs())
+	return report, nil
+}
+
+// 是否所有任务都已经执行完成，暂停的队列不会再执行，也算作完成
+func (w *WorkerQueue) drained() bool {
+	// 阻塞在暂停队列上的投递方永远等不到空位，先唤醒
+	w.provider.each(func(queue *JobQueue) bool {
+		queue.closeIfPaused()
+		return true
+	})
+
+	if w.posting.Load() > 0 {
+		return false
+	}
+
+	drained := true
+	w.provider.each(func(queue *JobQueue) bool {
+		drained = queue.isSettled()
+		return drained
+	})
+
+	return drained
+}
+
+// 丢弃所有还没有到期的延迟任务
+func (w *WorkerQueue) stopTimers() *ShutdownReport {
+	report := &ShutdownReport{Pending: map[uint64]int{}}
+	for _, timer := range w.timers.stop() {
+		report.Pending[timer.key]++
+		timer.task.drop(ErrStopped)
+	}
+
+	return report
+}
+
+// 丢弃所有未执行的任务，记录到 report 中
+func (w *WorkerQueue) dropPending(report *ShutdownReport) {
+	for _, queue := range w.provider.reset() {
+		tasks := queue.close()
+		if len(tasks) == 0 {
+			continue
+		}
+
+		report.Pending[queue.key] += len(tasks)
+		for _, t := range tasks {
+			t.drop(ErrStopped)
+		}
+	}
+}
+
+// 开始投递，工作队列已经停止时返回 ErrStopped
+func (w *WorkerQueue) beginPost() error {
+	w.posting.Add(1)
+	if w.stopped.Load() {
+		w.posting.Add(-1)
+		return ErrStopped
+	}
+
+	return nil
+}
+
+func (w *WorkerQueue) endPost() {
+	w.posting.Add(-1)
 }
 
 func (w *WorkerQueue) onTimer() {
 	time.AfterFunc(time.Minute, func() {
 		w.ClearIdleProvider()
 
-		if !w.stopped {
+		if !w.stopped.Load() {
 			w.onTimer()
 		}
 	})
@@ -177,32 +758,47 @@ func (w *WorkerQueue) onTimer() {
 
 // FetchProvider 获取任务队列
 func (w *WorkerQueue) FetchProvider(idx uint64) *JobQueue {
-	w.providerMutex.Lock()
-	defer w.providerMutex.Unlock()
+	return w.fetchProvider(idx, nil)
+}
 
-	queue, ok := w.provider[idx]
-	if !ok {
-		queue = &JobQueue{
-			jobs:       NewQueue(),
-			needSubmit: true,
-			BaseWorker: w,
-		}
-		w.provider[idx] = queue
+// 获取任务队列，精确路由模式下按照原始key区分队列，hash key 冲突时上报
+func (w *WorkerQueue) fetchProvider(idx uint64, rawKey []byte) *JobQueue {
+	pk := providerKey{hash: idx}
+	if w.config().ExactKeyRouting {
+		pk.raw = string(rawKey)
+	}
+
+	queue, collided := w.provider.fetch(pk, func() *JobQueue {
+		return newJobQueue(idx, pk.raw, w.config().QueueType, w)
+	})
+	if collided {
+		metrics.ReportKeyCollision(idx)
 	}
 
 	return queue
 }
 
-// ClearIdleProvider 清除空闲队列
+// 获取所有的任务队列
+func (w *WorkerQueue) providers() []*JobQueue {
+	return w.provider.all()
+}
+
+// ClearIdleProvider 清除空闲队列，按分片依次清除，不会阻塞其他分片的投递
 func (w *WorkerQueue) ClearIdleProvider() {
-	w.providerMutex.Lock()
-	defer w.providerMutex.Unlock()
+	w.provider.clearIdle()
+}
 
-	for idx, queue := range w.provider {
-		if queue.IsIdle() {
-			delete(w.provider, idx)
-		}
+// Logger 工作队列的日志，没有设置时使用 logger.Default()
+func (w *WorkerQueue) Logger() logger.Logger {
+	if w.options.logger != nil {
+		return w.options.logger
 	}
+
+	return logger.Default()
+}
+
+func (w *WorkerQueue) Tracer() trace.Tracer {
+	return w.tracer
 }
 
 func (w *WorkerQueue) ConsumerPool() *ants.Pool {
@@ -210,30 +806,227 @@ func (w *WorkerQueue) ConsumerPool() *ants.Pool {
 }
 
 func (w *WorkerQueue) MaxJobsPerWorker() int32 {
-	return w.cfg.MaxJobsPerWorker
+	return w.config().MaxJobsPerWorker
+}
+
+func (w *WorkerQueue) MaxTimePerWorker() time.Duration {
+	return w.config().MaxTimePerWorker
+}
+
+func (w *WorkerQueue) MaxJobsPerKey() int32 {
+	return w.config().MaxJobsPerKey
+}
+
+func (w *WorkerQueue) OverflowPolicy() OverflowPolicy {
+	return w.config().OverflowPolicy
+}
+
+func (w *WorkerQueue) HandlePanic(key uint64, recovered any, stack []byte) PanicPolicy {
+	cfg := w.config()
+	if cfg.OnPanic != nil {
+		cfg.OnPanic(key, recovered, stack)
+	} else {
+		w.Logger().Error("job panic", "key", key, "recovered", recovered, "stack", string(stack))
+	}
+
+	return cfg.PanicPolicy
+}
+
+func (w *WorkerQueue) HandleError(key uint64, err error) {
+	cfg := w.config()
+	if cfg.OnError != nil {
+		cfg.OnError(key, err)
+		return
+	}
+
+	w.Logger().Error("job error", "key", key, "error", err)
+}
+
+func (w *WorkerQueue) Schedule(j *JobQueue, class string) bool {
+	if len(w.config().ClassWeights) == 0 {
+		return false
+	}
+
+	w.scheduler.enqueue(j, class)
+	return true
+}
+
+// 分类的权重，没有配置的分类使用 DefaultClass 的权重，默认为1
+func (w *WorkerQueue) classWeight(class string) int {
+	weights := w.config().ClassWeights
+	if weight, ok := weights[class]; ok {
+		return weight
+	}
+	if weight, ok := weights[DefaultClass]; ok {
+		return weight
+	}
+
+	return 1
+}
+
+// 分类是否配置了权重
+func (w *WorkerQueue) hasClassWeight(class string) bool {
+	_, ok := w.config().ClassWeights[class]
+	return ok
+}
+
+// Resume 恢复因为panic暂停的队列
+func (w *WorkerQueue) Resume(key uint64, opts ...DispatchOption) {
+	w.fetchProvider(key, rawKeyOf(opts)).Resume()
 }
 
 // Dispatch 任务分发
-func (w *WorkerQueue) Dispatch(key uint64, f Job) {
-	queue := w.FetchProvider(key)
-	queue.Post(f)
+func (w *WorkerQueue) Dispatch(key uint64, f Job, opts ...DispatchOption) error {
+	return w.dispatch(key, newTask(f, opts))
+}
+
+// DispatchContext 携带上下文的任务分发
+func (w *WorkerQueue) DispatchContext(ctx context.Context, key uint64, f ContextJob, opts ...DispatchOption) error {
+	return w.dispatch(key, newContextTask(ctx, f, opts))
+}
+
+// DispatchAfter 延迟任务分发，到期后进入key的队列，和key的其他任务串行执行
+// 到期投递失败时通过 WithDropHandler 回调
+func (w *WorkerQueue) DispatchAfter(key uint64, delay time.Duration, f Job, opts ...DispatchOption) (*Timer, error) {
+	if w.stopped.Load() {
+		return nil, ErrStopped
+	}
+
+	t := newTask(f, opts)
+	timer := newTimer(key, t, func() {
+		if err := w.dispatch(key, t); err != nil {
+			t.drop(err)
+		}
+	})
+	if !w.timers.add(delay, timer) {
+		return nil, ErrStopped
+	}
+
+	return timer, nil
+}
+
+// DispatchEvery 周期任务分发，每个周期投递一次，和key的其他任务串行执行
+// 上一次投递的任务还没有执行完成时跳过本次，不会在队列中堆积，投递失败时通过 WithDropHandler 回调
+func (w *WorkerQueue) DispatchEvery(key uint64, interval time.Duration, f Job, opts ...DispatchOption) (*Timer, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("dispatch interval %v is invalid", interval)
+	}
+
+	if w.stopped.Load() {
+		return nil, ErrStopped
+	}
+
+	var (
+		timer *Timer
+		// 上一次投递的任务还没有执行完成
+		queued atomic.Bool
+		// 下一次到期的时间，按照固定间隔计算，避免误差累积
+		next = time.Now().Add(interval)
+	)
+	timer = newTimer(key, newTask(f, opts), func() {
+		for next = next.Add(interval); !next.After(time.Now()); {
+			next = next.Add(interval)
+		}
+		w.timers.add(time.Until(next), timer)
+
+		if !queued.CompareAndSwap(false, true) {
+			return
+		}
+
+		t := newTask(func() {
+			defer queued.Store(false)
+			if !timer.stopped() {
+				f()
+			}
+		}, opts)
+		dropped := t.dropped
+		t.dropped = func(err error) {
+			queued.Store(false)
+			if dropped != nil {
+				dropped(err)
+			}
+		}
+
+		if err := w.dispatch(key, t); err != nil {
+			t.drop(err)
+		}
+	})
+	timer.interval = interval
+
+	if !w.timers.add(interval, timer) {
+		return nil, ErrStopped
+	}
+
+	return timer, nil
+}
+
+func (w *WorkerQueue) dispatch(key uint64, t *task) error {
+	if err := w.beginPost(); err != nil {
+		return err
+	}
+	defer w.endPost()
+
+	queue, err := w.postToProvider(key, t.rawKey, func(queue *JobQueue) error {
+		return queue.post(t)
+	})
+	if err != nil {
+		return err
+	}
 
 	metrics.ReportJobCount(key, int64(queue.Size()))
+	return nil
+}
+
+// 投递到key的队列，队列在获取后被空闲回收时重新获取
+func (w *WorkerQueue) postToProvider(key uint64, rawKey []byte, post func(queue *JobQueue) error) (*JobQueue, error) {
+	for {
+		queue := w.fetchProvider(key, rawKey)
+		if err := post(queue); err != errRetired {
+			return queue, err
+		}
+	}
+}
+
+// DispatchAndWait 任务分发，并等待任务执行完成
+func (w *WorkerQueue) DispatchAndWait(key uint64, f Job, opts ...DispatchOption) error {
+	if err := w.beginPost(); err != nil {
+		return err
+	}
+	defer w.endPost()
+
+	_, err := w.postToProvider(key, rawKeyOf(opts), func(queue *JobQueue) error {
+		metrics.ReportJobCount(key, int64(queue.Size()+1))
+		return queue.PostAndWait(f)
+	})
+
+	return err
 }
 
 // JobsBuffLen 获取任务队列长度
-func (w *WorkerQueue) JobsBuffLen(key uint64) int {
-	queue := w.FetchProvider(key)
+func (w *WorkerQueue) JobsBuffLen(key uint64, opts ...DispatchOption) int {
+	queue := w.fetchProvider(key, rawKeyOf(opts))
 	return queue.Size()
 }
 
-// NewWorkQueue 初始化 worker queue
-func NewWorkQueue(cfg *PipelineConfig) BaseWorkerQueue {
+// NewWorkQueue 初始化 worker queue，配置不合法或者消费池创建失败时返回错误
+func NewWorkQueue(cfg *PipelineConfig, opts ...WorkerOption) (BaseWorkerQueue, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	wq := &WorkerQueue{
-		cfg:      cfg,
-		provider: make(map[uint64]*JobQueue),
+		provider: newProviderRegistry(cfg.ProviderShards),
+	}
+	for _, opt := range opts {
+		opt(&wq.options)
+	}
+
+	newCfg := *cfg
+	wq.cfg.Store(&newCfg)
+
+	if err := wq.start(); err != nil {
+		return nil, err
 	}
 
-	wq.start()
-	return wq
+	return wq, nil
 }
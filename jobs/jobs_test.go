@@ -1,10 +1,24 @@
 package jobs
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"math"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"pipeline/logger"
+	"pipeline/metrics"
 )
 
 func TestWorkerQueue(t *testing.T) {
@@ -31,7 +45,7 @@ func TestWorkerQueue(t *testing.T) {
 		},
 	}
 
-	defaultWorkQueue := NewWorkQueue(GetDefaultConfig())
+	defaultWorkQueue := mustNewWorkQueue(GetDefaultConfig())
 
 	for _, c := range cases {
 		t.Run(c.Name, func(t *testing.T) {
@@ -82,7 +96,7 @@ func TestJobPanic(t *testing.T) {
 		},
 	}
 
-	defaultWorkQueue := NewWorkQueue(GetDefaultConfig())
+	defaultWorkQueue := mustNewWorkQueue(GetDefaultConfig())
 
 	for _, c := range cases {
 		t.Run(c.Name, func(t *testing.T) {
@@ -117,3 +131,1219 @@ func TestJobPanic(t *testing.T) {
 	}
 
 }
+
+func TestDispatchAndWait(t *testing.T) {
+	defaultWorkQueue := mustNewWorkQueue(GetDefaultConfig())
+
+	count := 0
+	defaultWorkQueue.DispatchAndWait(1, func() {
+		count++
+	})
+	if count != 1 {
+		t.Fatalf("expected %v, got %v", 1, count)
+	}
+
+	// 在任务中重入等待同一个队列，不能死锁
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		defaultWorkQueue.DispatchAndWait(1, func() {
+			defaultWorkQueue.DispatchAndWait(1, func() {
+				count++
+			})
+			count++
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("reentrant wait deadlock")
+	}
+
+	if count != 3 {
+		t.Fatalf("expected %v, got %v", 3, count)
+	}
+
+	// 任务 panic 时返回错误，panic 继续交给 PanicPolicy 处理
+	cfg := GetDefaultConfig()
+	var panicked atomic.Int32
+	cfg.OnPanic = func(key uint64, recovered any, stack []byte) {
+		panicked.Add(1)
+	}
+	workQueue := mustNewWorkQueue(cfg)
+
+	var panicErr *PanicError
+	if err := workQueue.DispatchAndWait(1, func() { panic("boom") }); !errors.As(err, &panicErr) || panicErr.Recovered != "boom" {
+		t.Fatalf("expected panic error, got %v", err)
+	}
+	workQueue.DispatchAndWait(1, func() {})
+	if panicked.Load() != 1 {
+		t.Fatalf("expected panic handled %v, got %v", 1, panicked.Load())
+	}
+
+	// 重入执行时 panic 同样返回错误，不会中断外层的任务
+	var reentrantErr error
+	if err := workQueue.DispatchAndWait(1, func() {
+		reentrantErr = workQueue.DispatchAndWait(1, func() { panic("reentrant") })
+	}); err != nil {
+		t.Fatalf("expected outer job completed, got %v", err)
+	}
+	if !errors.As(reentrantErr, &panicErr) || panicErr.Recovered != "reentrant" {
+		t.Fatalf("expected reentrant panic error, got %v", reentrantErr)
+	}
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Policy   OverflowPolicy
+		PostErr  error
+		Expected []int
+		Dropped  []int
+	}{
+		{"reject", OverflowReject, ErrQueueFull, []int{0, 1}, nil},
+		{"drop oldest", OverflowDropOldest, nil, []int{1, 2}, []int{0}},
+		{"drop newest", OverflowDropNewest, nil, []int{0, 1}, []int{2}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			cfg := GetDefaultConfig()
+			cfg.MaxJobsPerKey = 2
+			cfg.OverflowPolicy = c.Policy
+			workQueue := mustNewWorkQueue(cfg)
+
+			// 阻塞住消费协程，后续任务都留在队列中
+			block := make(chan struct{})
+			started := make(chan struct{})
+			workQueue.Dispatch(1, func() {
+				close(started)
+				<-block
+			})
+			<-started
+
+			mu := sync.Mutex{}
+			executed, dropped := []int{}, []int{}
+			for i := 0; i < 3; i++ {
+				i := i
+				err := workQueue.Dispatch(1, func() {
+					mu.Lock()
+					defer mu.Unlock()
+					executed = append(executed, i)
+				}, WithDropHandler(func(err error) {
+					mu.Lock()
+					defer mu.Unlock()
+					dropped = append(dropped, i)
+				}))
+				if i == 2 && err != c.PostErr {
+					t.Fatalf("%s: expected err %v, got %v", c.Name, c.PostErr, err)
+				}
+			}
+
+			close(block)
+			for workQueue.JobsBuffLen(1) != 0 {
+				time.Sleep(time.Millisecond)
+			}
+			workQueue.DispatchAndWait(1, func() {})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if fmt.Sprint(executed) != fmt.Sprint(c.Expected) {
+				t.Fatalf("%s: expected executed %v, got %v", c.Name, c.Expected, executed)
+			}
+			if len(dropped) != len(c.Dropped) || len(dropped) > 0 && fmt.Sprint(dropped) != fmt.Sprint(c.Dropped) {
+				t.Fatalf("%s: expected dropped %v, got %v", c.Name, c.Dropped, dropped)
+			}
+		})
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.MaxJobsPerKey = 1
+	workQueue := mustNewWorkQueue(cfg)
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		close(started)
+		<-block
+	})
+	<-started
+	workQueue.Dispatch(1, func() {})
+
+	posted := make(chan struct{})
+	go func() {
+		defer close(posted)
+		workQueue.Dispatch(1, func() {})
+	}()
+
+	select {
+	case <-posted:
+		t.Fatalf("post should block while queue is full")
+	case <-time.After(time.Millisecond * 10):
+	}
+
+	close(block)
+	select {
+	case <-posted:
+	case <-time.After(time.Second):
+		t.Fatalf("post should be unblocked")
+	}
+}
+
+func TestOverflowBlockStop(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.MaxJobsPerKey = 1
+	workQueue := mustNewWorkQueue(cfg)
+
+	block := make(chan struct{})
+	defer close(block)
+
+	started := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		close(started)
+		<-block
+	})
+	<-started
+	workQueue.Dispatch(1, func() {})
+
+	// 阻塞的投递方在停止后返回错误，不能进入已经清空的队列
+	posted := make(chan error, 1)
+	go func() {
+		posted <- workQueue.Dispatch(1, func() {})
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	workQueue.Stop()
+	select {
+	case err := <-posted:
+		if err != ErrStopped {
+			t.Fatalf("expected %v, got %v", ErrStopped, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("post should be unblocked")
+	}
+}
+
+func TestClearIdleProvider(t *testing.T) {
+	workQueue := mustNewWorkQueue(GetDefaultConfig()).(*WorkerQueue)
+
+	// 获取队列后被回收，投递重新获取队列，同一个key不会出现两个队列
+	queue := workQueue.FetchProvider(1)
+	workQueue.ClearIdleProvider()
+	if err := queue.Post(func() {}); err != errRetired {
+		t.Fatalf("expected %v, got %v", errRetired, err)
+	}
+
+	count := 0
+	workQueue.Dispatch(1, func() { count++ })
+	if err := workQueue.DispatchAndWait(1, func() { count++ }); err != nil {
+		t.Fatalf("dispatch and wait err %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected %v, got %v", 2, count)
+	}
+	if workQueue.FetchProvider(1) == queue {
+		t.Fatalf("expected new queue after clear")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	workQueue := mustNewWorkQueue(GetDefaultConfig())
+
+	count := 0
+	for i := 0; i < 100; i++ {
+		workQueue.Dispatch(1, func() {
+			time.Sleep(time.Microsecond * 10)
+			count++
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	report, err := workQueue.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("shutdown err %v", err)
+	}
+	if report.PendingJobs() != 0 {
+		t.Fatalf("expected pending %v, got %v", 0, report.PendingJobs())
+	}
+	if count != 100 {
+		t.Fatalf("expected %v, got %v", 100, count)
+	}
+
+	if err := workQueue.Dispatch(1, func() {}); err != ErrStopped {
+		t.Fatalf("expected %v, got %v", ErrStopped, err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	workQueue := mustNewWorkQueue(GetDefaultConfig())
+
+	block := make(chan struct{})
+	defer close(block)
+
+	started := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	dropped := 0
+	for i := 0; i < 10; i++ {
+		workQueue.Dispatch(1, func() {}, WithDropHandler(func(err error) {
+			if err != ErrStopped {
+				t.Errorf("expected %v, got %v", ErrStopped, err)
+			}
+			dropped++
+		}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	report, err := workQueue.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if report.Pending[1] != 10 || dropped != 10 {
+		t.Fatalf("expected pending %v, got %v dropped %v", 10, report.Pending[1], dropped)
+	}
+}
+
+func TestShutdownPaused(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.PanicPolicy = PanicPause
+	cfg.OnPanic = func(key uint64, recovered any, stack []byte) {}
+	workQueue := mustNewWorkQueue(cfg)
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	workQueue.Dispatch(1, func() {
+		panic("this is a test panic")
+	})
+	dropped := 0
+	for i := 0; i < 3; i++ {
+		workQueue.Dispatch(1, func() {}, WithDropHandler(func(err error) {
+			if err != ErrStopped {
+				t.Errorf("expected %v, got %v", ErrStopped, err)
+			}
+			dropped++
+		}))
+	}
+	close(block)
+	for workQueue.JobsBuffLen(1) != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 暂停的队列不会阻塞停止，积压的任务记录到 report 中
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+
+	report, err := workQueue.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("shutdown err %v", err)
+	}
+	if report.Pending[1] != 3 || dropped != 3 {
+		t.Fatalf("expected pending %v, got %v dropped %v", 3, report.Pending[1], dropped)
+	}
+}
+
+func TestShutdownPausedBlocked(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.MaxJobsPerKey = 2
+	cfg.PanicPolicy = PanicPause
+	cfg.OnPanic = func(key uint64, recovered any, stack []byte) {}
+	workQueue := mustNewWorkQueue(cfg)
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		close(started)
+		<-block
+	})
+	<-started
+	workQueue.Dispatch(1, func() {
+		panic("this is a test panic")
+	})
+	workQueue.Dispatch(1, func() {})
+	close(block)
+	for workQueue.JobsBuffLen(1) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 暂停的队列已满，投递方阻塞等待空位
+	workQueue.Dispatch(1, func() {})
+	posted := make(chan error, 1)
+	go func() {
+		posted <- workQueue.Dispatch(1, func() {})
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+
+	report, err := workQueue.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("shutdown err %v", err)
+	}
+	if report.Pending[1] != 2 {
+		t.Fatalf("expected pending %v, got %v", 2, report.Pending[1])
+	}
+	if err := <-posted; err != ErrStopped {
+		t.Fatalf("expected %v, got %v", ErrStopped, err)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	workQueue := mustNewWorkQueue(GetDefaultConfig())
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	result := []int{}
+	for i := 0; i < 10; i++ {
+		i := i
+		workQueue.Dispatch(1, func() {
+			result = append(result, i)
+		})
+	}
+
+	if err := workQueue.Reconfigure(&PipelineConfig{}); err == nil {
+		t.Fatalf("invalid config should be rejected")
+	}
+
+	cfg := &PipelineConfig{
+		MaxWorkerQueueCount: 10,
+		MaxJobsPerWorker:    1,
+	}
+	if err := workQueue.Reconfigure(cfg); err != nil {
+		t.Fatalf("reconfigure err %v", err)
+	}
+	if size := workQueue.(*WorkerQueue).ConsumerPool().Cap(); size != 10 {
+		t.Fatalf("expected pool size %v, got %v", 10, size)
+	}
+
+	close(block)
+	workQueue.DispatchAndWait(1, func() {})
+
+	if fmt.Sprint(result) != fmt.Sprint([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Fatalf("expected FIFO after reconfigure, got %v", result)
+	}
+}
+
+func TestPanicPolicy(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Policy   PanicPolicy
+		Expected int
+		Dropped  int
+	}{
+		{"continue", PanicContinue, 4, 0},
+		{"pause", PanicPause, 1, 0},
+		{"drop backlog", PanicDropBacklog, 1, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			panics := 0
+			cfg := GetDefaultConfig()
+			cfg.PanicPolicy = c.Policy
+			cfg.OnPanic = func(key uint64, recovered any, stack []byte) {
+				panics++
+			}
+			workQueue := mustNewWorkQueue(cfg)
+
+			block := make(chan struct{})
+			started := make(chan struct{})
+			workQueue.Dispatch(1, func() {
+				close(started)
+				<-block
+			})
+			<-started
+
+			executed, dropped := 0, 0
+			workQueue.Dispatch(1, func() {
+				executed++
+			})
+			workQueue.Dispatch(1, func() {
+				panic("this is a test panic")
+			})
+			for i := 0; i < 3; i++ {
+				workQueue.Dispatch(1, func() {
+					executed++
+				}, WithDropHandler(func(err error) {
+					dropped++
+				}))
+			}
+
+			close(block)
+			for {
+				if workQueue.JobsBuffLen(1) == 0 || c.Policy == PanicPause && workQueue.JobsBuffLen(1) == 3 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(time.Millisecond * 10)
+
+			if executed != c.Expected || dropped != c.Dropped || panics != 1 {
+				t.Fatalf("%s: expected executed %v dropped %v, got %v %v panics %v",
+					c.Name, c.Expected, c.Dropped, executed, dropped, panics)
+			}
+
+			if c.Policy == PanicPause {
+				workQueue.Resume(1)
+				workQueue.DispatchAndWait(1, func() {})
+				if executed != 4 {
+					t.Fatalf("%s: expected executed %v after resume, got %v", c.Name, 4, executed)
+				}
+			}
+		})
+	}
+}
+
+func TestJobLatencyMetrics(t *testing.T) {
+	reporter := metrics.NewMemoryReporter()
+	metrics.SetReporter(reporter)
+	defer metrics.SetReporter(nil)
+
+	workQueue := mustNewWorkQueue(GetDefaultConfig())
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	workQueue.Dispatch(1, func() {
+		time.Sleep(time.Millisecond * 5)
+	})
+
+	time.Sleep(time.Millisecond * 10)
+	close(block)
+	workQueue.DispatchAndWait(1, func() {})
+
+	// 最后一个任务的执行时间在等待方返回之后才上报
+	waits, consumes := reporter.JobWaits(1), reporter.JobConsumes(1)
+	if len(waits) != 3 || len(consumes) < 2 {
+		t.Fatalf("expected %v reports, got wait %v consume %v", 3, len(waits), len(consumes))
+	}
+	if waits[1] < time.Millisecond*10 {
+		t.Fatalf("expected wait >= %v, got %v", time.Millisecond*10, waits[1])
+	}
+	if consumes[1] < time.Millisecond*5 {
+		t.Fatalf("expected consume >= %v, got %v", time.Millisecond*5, consumes[1])
+	}
+}
+
+func TestSlowJobWatchdog(t *testing.T) {
+	reporter := metrics.NewMemoryReporter()
+	metrics.SetReporter(reporter)
+	defer metrics.SetReporter(nil)
+
+	cfg := GetDefaultConfig()
+	cfg.SlowJobThreshold = time.Millisecond * 20
+	workQueue := mustNewWorkQueue(cfg)
+
+	block := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		<-block
+	})
+
+	var stuck []StuckKey
+	for i := 0; i < 100 && len(stuck) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+		stuck = workQueue.StuckKeys()
+	}
+
+	if len(stuck) != 1 || stuck[0].Key != 1 {
+		t.Fatalf("expected stuck key %v, got %+v", 1, stuck)
+	}
+	if !strings.Contains(string(stuck[0].Stack), "TestSlowJobWatchdog") {
+		t.Fatalf("expected stack of stuck job, got %s", stuck[0].Stack)
+	}
+
+	// 同一个任务只上报一次
+	time.Sleep(time.Millisecond * 50)
+	if timeouts := reporter.JobTimeouts(1); timeouts != 1 {
+		t.Fatalf("expected timeouts %v, got %v", 1, timeouts)
+	}
+
+	close(block)
+	for i := 0; i < 100 && len(stuck) != 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+		stuck = workQueue.StuckKeys()
+	}
+	if len(stuck) != 0 {
+		t.Fatalf("expected no stuck key, got %+v", stuck)
+	}
+
+	// 没有开启时使用最大检查间隔
+	if interval := mustNewWorkQueue(GetDefaultConfig()).(*WorkerQueue).watchdogInterval(); interval != maxWatchdogInterval {
+		t.Fatalf("expected interval %v, got %v", maxWatchdogInterval, interval)
+	}
+}
+
+func TestExactKeyRouting(t *testing.T) {
+	cases := []struct {
+		Name       string
+		Exact      bool
+		Serialized bool
+		Collisions int
+	}{
+		{"hash routing", false, true, 0},
+		{"exact routing", true, false, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			reporter := metrics.NewMemoryReporter()
+			metrics.SetReporter(reporter)
+			defer metrics.SetReporter(nil)
+
+			cfg := GetDefaultConfig()
+			cfg.ExactKeyRouting = c.Exact
+			workQueue := mustNewWorkQueue(cfg)
+
+			// 两个不同的key使用同一个hash key
+			block := make(chan struct{})
+			defer close(block)
+			workQueue.Dispatch(1, func() {
+				<-block
+			}, WithRawKey([]byte("a")))
+
+			done := make(chan struct{})
+			workQueue.Dispatch(1, func() {
+				close(done)
+			}, WithRawKey([]byte("b")))
+
+			serialized := false
+			select {
+			case <-done:
+			case <-time.After(time.Millisecond * 50):
+				serialized = true
+			}
+
+			if serialized != c.Serialized {
+				t.Fatalf("%s: expected serialized %v, got %v", c.Name, c.Serialized, serialized)
+			}
+			if collisions := reporter.KeyCollisions(1); collisions != c.Collisions {
+				t.Fatalf("%s: expected collisions %v, got %v", c.Name, c.Collisions, collisions)
+			}
+			if buffLen := workQueue.JobsBuffLen(1, WithRawKey([]byte("b"))); c.Exact && buffLen != 0 {
+				t.Fatalf("%s: expected buff len %v, got %v", c.Name, 0, buffLen)
+			}
+		})
+	}
+
+	cfg := GetDefaultConfig()
+	workQueue := mustNewWorkQueue(cfg)
+	cfg = GetDefaultConfig()
+	cfg.ExactKeyRouting = true
+	if err := workQueue.Reconfigure(cfg); err == nil {
+		t.Fatalf("exact key routing should not be changed online")
+	}
+}
+
+// 高基数key下对比分片和单个map(分片数为1，与原来的全局锁一致)的投递性能
+func benchmarkProviderShards(b *testing.B, shards int32, keyCount uint64, sweep bool) {
+	cfg := GetDefaultConfig()
+	cfg.ProviderShards = shards
+	workQueue := mustNewWorkQueue(cfg)
+	defer workQueue.Stop()
+
+	// 模拟定时清理空闲队列
+	stop := make(chan struct{})
+	defer close(stop)
+	if sweep {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					workQueue.(*WorkerQueue).ClearIdleProvider()
+				}
+			}
+		}()
+	}
+
+	seq := atomic.Uint64{}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			workQueue.Dispatch(seq.Add(1)%keyCount, func() {})
+		}
+	})
+}
+
+func BenchmarkProviderShards1_100000(b *testing.B) { benchmarkProviderShards(b, 1, 100000, false) }
+
+func BenchmarkProviderShards64_100000(b *testing.B) { benchmarkProviderShards(b, 64, 100000, false) }
+
+func BenchmarkProviderShards1_100000Sweep(b *testing.B) { benchmarkProviderShards(b, 1, 100000, true) }
+
+func BenchmarkProviderShards64_100000Sweep(b *testing.B) {
+	benchmarkProviderShards(b, 64, 100000, true)
+}
+
+func TestTaskQueue(t *testing.T) {
+	for _, queueType := range []QueueType{QueueRing, QueueList} {
+		t.Run(string(queueType), func(t *testing.T) {
+			queue := newTaskQueue(queueType)
+			if queue.Dequeue() != nil || queue.Peek() != nil {
+				t.Fatalf("%s: empty queue should return nil", queueType)
+			}
+
+			// 跨越多个分块交替入队出队，检查先进先出
+			tasks := make([]*task, ringChunkSize*3+1)
+			for i := range tasks {
+				tasks[i] = &task{}
+			}
+
+			next := 0
+			for i, task := range tasks {
+				queue.Enqueue(task)
+				if i%3 == 0 {
+					if got := queue.Dequeue(); got != tasks[next] {
+						t.Fatalf("%s: expected task %v, got %p", queueType, next, got)
+					}
+					next++
+				}
+			}
+
+			if queue.Size() != len(tasks)-next {
+				t.Fatalf("%s: expected size %v, got %v", queueType, len(tasks)-next, queue.Size())
+			}
+
+			for ; next < len(tasks); next++ {
+				if queue.Peek() != tasks[next] || queue.Dequeue() != tasks[next] {
+					t.Fatalf("%s: expected task %v", queueType, next)
+				}
+			}
+
+			if queue.Size() != 0 || queue.Dequeue() != nil {
+				t.Fatalf("%s: queue should be empty", queueType)
+			}
+		})
+	}
+}
+
+func benchmarkTaskQueue(b *testing.B, queueType QueueType) {
+	queue := newTaskQueue(queueType)
+	t := &task{}
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < 100; i++ {
+			queue.Enqueue(t)
+		}
+		for i := 0; i < 100; i++ {
+			queue.Dequeue()
+		}
+	}
+}
+
+func BenchmarkTaskQueueRing(b *testing.B) { benchmarkTaskQueue(b, QueueRing) }
+
+func BenchmarkTaskQueueList(b *testing.B) { benchmarkTaskQueue(b, QueueList) }
+
+func TestFairScheduling(t *testing.T) {
+	reporter := metrics.NewMemoryReporter()
+	metrics.SetReporter(reporter)
+	defer metrics.SetReporter(nil)
+
+	cfg := GetDefaultConfig()
+	cfg.MaxWorkerQueueCount = 1
+	cfg.ClassWeights = map[string]int{"gold": 3, "bronze": 1}
+	workQueue := mustNewWorkQueue(cfg)
+	pool := workQueue.(*WorkerQueue).ConsumerPool()
+
+	// 第一个任务占住唯一的消费协程，第二个任务让调度器阻塞在提交上，后续的任务队列都在调度器中等待
+	block := make(chan struct{})
+	workQueue.Dispatch(0, func() { <-block })
+	workQueue.Dispatch(1, func() { <-block })
+	for pool.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	mu := sync.Mutex{}
+	result := ""
+	for i := 0; i < 8; i++ {
+		for j, class := range []string{"gold", "bronze"} {
+			name := class[:1]
+			key := uint64(100*(j+1) + i)
+			workQueue.Dispatch(key, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				result += name
+			}, WithClass(class))
+		}
+	}
+
+	close(block)
+	for i := 0; i < 100 && func() bool { mu.Lock(); defer mu.Unlock(); return len(result) < 16 }(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if result != "gggbgggbggbbbbbb" {
+		t.Fatalf("expected %v, got %v", "gggbgggbggbbbbbb", result)
+	}
+	if waits := reporter.ClassWaits("bronze"); len(waits) != 8 {
+		t.Fatalf("expected %v class waits, got %v", 8, len(waits))
+	}
+}
+
+func TestFairSchedulerClasses(t *testing.T) {
+	s := &fairScheduler{
+		classes:    make(map[string]*classQueue),
+		weight:     func(class string) int { return 1 },
+		configured: func(class string) bool { return class == "gold" },
+	}
+
+	// 没有配置权重的分类在空闲时移除
+	for i := 0; i < 100; i++ {
+		s.enqueue(&JobQueue{}, fmt.Sprintf("tenant-%d", i))
+		s.enqueue(&JobQueue{}, "gold")
+	}
+	picked := 0
+	for _, _, ok := s.pick(); ok; _, _, ok = s.pick() {
+		picked++
+	}
+
+	if picked != 200 {
+		t.Fatalf("expected picked %v, got %v", 200, picked)
+	}
+	if len(s.order) != 1 || len(s.classes) != 1 || s.order[0].name != "gold" {
+		t.Fatalf("expected only configured class left, got %v", len(s.order))
+	}
+}
+
+func TestMaxTimePerWorker(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.MaxWorkerQueueCount = 1
+	cfg.MaxJobsPerWorker = 100
+	cfg.MaxTimePerWorker = time.Millisecond * 20
+	workQueue := mustNewWorkQueue(cfg)
+
+	mu := sync.Mutex{}
+	result := []uint64{}
+	record := func(key uint64) Job {
+		return func() {
+			time.Sleep(time.Millisecond * 10)
+			mu.Lock()
+			defer mu.Unlock()
+			result = append(result, key)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		workQueue.Dispatch(1, record(1))
+	}
+	workQueue.DispatchAndWait(2, record(2))
+
+	// key 1 超过时间预算后让出协程，key 2 不需要等待 key 1 的全部任务
+	mu.Lock()
+	defer mu.Unlock()
+	if len(result) >= 11 {
+		t.Fatalf("expected key 2 to run before key 1 finished, got %v", result)
+	}
+}
+
+func TestAdaptiveBatchSize(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.MaxJobsPerWorker = 10
+	queue := newJobQueue(1, "", cfg.QueueType, mustNewWorkQueue(cfg).(*WorkerQueue))
+
+	if n := queue.batchSize(0); n != 10 {
+		t.Fatalf("expected batch size %v without budget, got %v", 10, n)
+	}
+	if n := queue.batchSize(time.Millisecond * 10); n != 10 {
+		t.Fatalf("expected batch size %v before observed, got %v", 10, n)
+	}
+
+	queue.observeJobCost(time.Millisecond * 2)
+	if n := queue.batchSize(time.Millisecond * 10); n != 5 {
+		t.Fatalf("expected batch size %v, got %v", 5, n)
+	}
+
+	for i := 0; i < 100; i++ {
+		queue.observeJobCost(time.Millisecond * 50)
+	}
+	if n := queue.batchSize(time.Millisecond * 10); n != 1 {
+		t.Fatalf("expected batch size %v for slow jobs, got %v", 1, n)
+	}
+
+	for i := 0; i < 100; i++ {
+		queue.observeJobCost(time.Microsecond)
+	}
+	if n := queue.batchSize(time.Millisecond * 10); n != 10 {
+		t.Fatalf("expected batch size %v for fast jobs, got %v", 10, n)
+	}
+}
+
+func TestTimingWheel(t *testing.T) {
+	wheel := newTimingWheel(time.Millisecond*5, 4)
+
+	fired := make(chan time.Duration, 3)
+	start := time.Now()
+	for _, delay := range []time.Duration{0, time.Millisecond * 12, time.Millisecond * 50} {
+		wheel.add(delay, newTimer(0, nil, func() {
+			fired <- time.Since(start)
+		}))
+	}
+
+	// 超过一圈的任务需要转动多圈后到期，不会提前
+	for _, expected := range []time.Duration{0, time.Millisecond * 12, time.Millisecond * 50} {
+		if elapsed := <-fired; elapsed < expected {
+			t.Fatalf("expected timer fired after %v, got %v", expected, elapsed)
+		}
+	}
+
+	wheel.Lock()
+	running := wheel.running
+	wheel.Unlock()
+	for i := 0; i < 10 && running; i++ {
+		time.Sleep(time.Millisecond * 5)
+		wheel.Lock()
+		running = wheel.running
+		wheel.Unlock()
+	}
+	if running {
+		t.Fatalf("expected idle wheel to stop running")
+	}
+
+	stopped := newTimer(0, nil, func() {})
+	wheel.add(time.Second, stopped)
+	if pending := wheel.stop(); len(pending) != 1 || pending[0] != stopped {
+		t.Fatalf("expected %v pending timer, got %v", 1, len(pending))
+	}
+	if wheel.add(0, newTimer(0, nil, func() {})) {
+		t.Fatalf("expected stopped wheel to reject timers")
+	}
+}
+
+func TestDelayedJobsBlockedKey(t *testing.T) {
+	cfg := GetDefaultConfig()
+	cfg.MaxJobsPerKey = 1
+	workQueue := mustNewWorkQueue(cfg)
+
+	block := make(chan struct{})
+	defer close(block)
+
+	started := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		close(started)
+		<-block
+	})
+	<-started
+	workQueue.Dispatch(1, func() {})
+
+	// key 1 已满，到期投递阻塞，不能影响其他key的延迟任务
+	workQueue.DispatchAfter(1, time.Millisecond, func() {})
+	fired := make(chan struct{})
+	workQueue.DispatchAfter(2, time.Millisecond*30, func() {
+		close(fired)
+	})
+
+	select {
+	case <-fired:
+	case <-time.After(time.Millisecond * 500):
+		t.Fatalf("delayed job of key 2 blocked by full key 1")
+	}
+}
+
+func TestShutdownDelayedJobs(t *testing.T) {
+	workQueue := mustNewWorkQueue(GetDefaultConfig())
+
+	dropped := make(chan error, 1)
+	workQueue.DispatchAfter(1, time.Hour, func() {}, WithDropHandler(func(err error) {
+		dropped <- err
+	}))
+
+	report, err := workQueue.Shutdown(context.Background())
+	if err != nil || report.Pending[1] != 1 {
+		t.Fatalf("expected %v pending delayed job, got %v %v", 1, report.Pending, err)
+	}
+	if err := <-dropped; err != ErrStopped {
+		t.Fatalf("expected %v, got %v", ErrStopped, err)
+	}
+	if _, err := workQueue.DispatchAfter(1, 0, func() {}); err != ErrStopped {
+		t.Fatalf("expected %v, got %v", ErrStopped, err)
+	}
+}
+
+func TestDispatchEvery(t *testing.T) {
+	workQueue := mustNewWorkQueue(GetDefaultConfig())
+
+	var ticks, overlapped atomic.Int32
+	timer, err := workQueue.DispatchEvery(1, time.Millisecond*20, func() {
+		// 上一次还没有执行完成时跳过，队列中不会堆积
+		if workQueue.JobsBuffLen(1) != 0 {
+			overlapped.Add(1)
+		}
+		ticks.Add(1)
+		time.Sleep(time.Millisecond * 50)
+	})
+	if err != nil {
+		t.Fatalf("dispatch every error %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 300)
+	if !timer.Stop() {
+		t.Fatalf("expected periodic timer to be stopped")
+	}
+	workQueue.DispatchAndWait(1, func() {})
+	stopped := ticks.Load()
+
+	if stopped < 3 || stopped > 6 {
+		t.Fatalf("expected about %v ticks, got %v", 5, stopped)
+	}
+	if overlapped.Load() != 0 {
+		t.Fatalf("expected no overlapped ticks, got %v", overlapped.Load())
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	if ticks.Load() != stopped {
+		t.Fatalf("expected no ticks after stop, got %v", ticks.Load()-stopped)
+	}
+
+	if _, err := workQueue.DispatchEvery(1, 0, func() {}); err == nil {
+		t.Fatalf("expected invalid interval error")
+	}
+}
+
+func mustNewWorkQueue(cfg *PipelineConfig) BaseWorkerQueue {
+	workQueue, err := NewWorkQueue(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	return workQueue
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pipeline.yaml": "max_worker_queue_count: 10\nslow_job_threshold: 1s\nclass_weights:\n  gold: 3\n",
+		"pipeline.json": `{"max_worker_queue_count": 10, "slow_job_threshold": "1s", "class_weights": {"gold": 3}}`,
+	}
+
+	for name, content := range files {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write config error %v", err)
+		}
+
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: load config error %v", name, err)
+		}
+		if cfg.MaxWorkerQueueCount != 10 || cfg.SlowJobThreshold != time.Second || cfg.ClassWeights["gold"] != 3 ||
+			cfg.MaxJobsPerWorker != DefaultMaxJobsPerWorker {
+			t.Fatalf("%s: unexpected config %+v", name, cfg)
+		}
+	}
+
+	t.Setenv(ConfigEnvPrefix+"MAX_JOBS_PER_WORKER", "20")
+	t.Setenv(ConfigEnvPrefix+"OVERFLOW_POLICY", "reject")
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("load config from env error %v", err)
+	}
+	if cfg.MaxJobsPerWorker != 20 || cfg.OverflowPolicy != OverflowReject {
+		t.Fatalf("unexpected config from env %+v", cfg)
+	}
+
+	t.Setenv(ConfigEnvPrefix+"MAX_JOBS_PER_WORKER", "0")
+	if _, err := LoadConfig(""); err == nil {
+		t.Fatalf("expected invalid config error")
+	}
+}
+
+func TestNewWorkQueueOptions(t *testing.T) {
+	if _, err := NewWorkQueue(&PipelineConfig{}); err == nil {
+		t.Fatalf("expected invalid config error")
+	}
+
+	cfg := GetDefaultConfig()
+	cfg.MaxWorkerQueueCount = 1
+	workQueue, err := NewWorkQueue(cfg, WithPreAlloc(true), WithNonblocking(true), WithExpiryDuration(time.Second))
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+
+	// 非阻塞的消费池已满时稍后重试提交，任务不会丢失
+	block := make(chan struct{})
+	workQueue.Dispatch(1, func() { <-block })
+	time.AfterFunc(time.Millisecond*30, func() { close(block) })
+	if err := workQueue.DispatchAndWait(2, func() {}); err != nil {
+		t.Fatalf("dispatch and wait error %v", err)
+	}
+
+	resized := *cfg
+	resized.MaxWorkerQueueCount = 2
+	if err := workQueue.Reconfigure(&resized); err == nil {
+		t.Fatalf("expected pre-alloc pool resize error")
+	}
+}
+
+func TestWorkerLogger(t *testing.T) {
+	buf := &lockedBuffer{}
+	workQueue, err := NewWorkQueue(GetDefaultConfig(), WithLogger(logger.NewSlog(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+
+	workQueue.Dispatch(1, func() {
+		panic("this is a test panic")
+	})
+	workQueue.DispatchAndWait(1, func() {})
+	workQueue.Shutdown(context.Background())
+
+	out := buf.String()
+	for _, expected := range []string{"msg=\"worker queue started\"", "msg=\"job panic\" key=1", "msg=\"worker queue shutdown\""} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("expected log %v, got %v", expected, out)
+		}
+	}
+
+	// 启停等生命周期事件使用 Debug 级别，默认级别下不输出
+	buf = &lockedBuffer{}
+	workQueue, err = NewWorkQueue(GetDefaultConfig(), WithLogger(logger.NewSlog(slog.NewTextHandler(buf, nil))))
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+	workQueue.Shutdown(context.Background())
+	if out := buf.String(); out != "" {
+		t.Fatalf("expected no lifecycle log at info level, got %v", out)
+	}
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestJobTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	workQueue, err := NewWorkQueue(GetDefaultConfig(), WithTracerProvider(tp))
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	workQueue.DispatchContext(ctx, 1, func(ctx context.Context) error {
+		_, child := tp.Tracer("test").Start(ctx, "child")
+		child.End()
+		return nil
+	})
+	workQueue.Dispatch(1, func() {
+		panic("this is a test panic")
+	}, WithTraceContext(ctx))
+	workQueue.DispatchAndWait(1, func() {})
+	parent.End()
+	workQueue.Shutdown(context.Background())
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	jobs := spans[jobSpanName]
+	if len(jobs) != 3 {
+		t.Fatalf("expected %v job spans, got %v", 3, len(jobs))
+	}
+	for i, span := range jobs[:2] {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Fatalf("expected job span %v parent to be caller span", i)
+		}
+		attrs := map[string]bool{}
+		for _, attr := range span.Attributes() {
+			attrs[string(attr.Key)] = true
+		}
+		if !attrs["pipeline.key_hash"] || !attrs["pipeline.queue_wait_us"] || !attrs["pipeline.batch_position"] {
+			t.Fatalf("expected job span attributes, got %v", span.Attributes())
+		}
+	}
+	if jobs[1].Status().Code != codes.Error {
+		t.Fatalf("expected panic job span error status, got %v", jobs[1].Status())
+	}
+	if jobs[2].Parent().IsValid() {
+		t.Fatalf("expected job without trace context to be root span")
+	}
+
+	if len(spans["child"]) != 1 || spans["child"][0].Parent().SpanID() != jobs[0].SpanContext().SpanID() {
+		t.Fatalf("expected child span under job span")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	workQueue := mustNewWorkQueue(GetDefaultConfig())
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	workQueue.Dispatch(1, func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	workQueue.Dispatch(1, func() {})
+	time.Sleep(time.Millisecond * 10)
+	workQueue.Dispatch(1, func() {}, WithPriority(PriorityHigh))
+	workQueue.DispatchAndWait(2, func() {})
+
+	snapshot := workQueue.Snapshot()
+	if len(snapshot.Keys) != 1 || snapshot.Backlog != 2 || snapshot.Pool.Running < 1 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	// 最早入队的任务在低优先级的队列中
+	key := snapshot.Keys[0]
+	if key.Key != 1 || key.Backlog != 2 || !key.Running || key.OldestJobAge < time.Millisecond*10 || key.RunningFor < key.OldestJobAge {
+		t.Fatalf("unexpected key snapshot %+v", key)
+	}
+
+	close(block)
+	workQueue.DispatchAndWait(1, func() {})
+	for i := 0; i < 100 && len(workQueue.Snapshot().Keys) != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if keys := workQueue.Snapshot().Keys; len(keys) != 0 {
+		t.Fatalf("expected no active keys, got %+v", keys)
+	}
+}
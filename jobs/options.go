@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+	"go.opentelemetry.io/otel/trace"
+
+	"pipeline/logger"
+)
+
+// 消费池的配置，只在创建时生效
+type workerOptions struct {
+	expiryDuration time.Duration
+	preAlloc       bool
+	nonblocking    bool
+	panicHandler   func(any)
+	poolLogger     ants.Logger
+	logger         logger.Logger
+	tracerProvider trace.TracerProvider
+}
+
+// WorkerOption 工作队列的创建选项
+type WorkerOption func(o *workerOptions)
+
+// WithExpiryDuration 消费池空闲协程的回收间隔，默认使用 ants 的默认值
+func WithExpiryDuration(d time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.expiryDuration = d
+	}
+}
+
+// WithPreAlloc 预分配消费池的协程，开启后不支持在线调整 MaxWorkerQueueCount
+func WithPreAlloc(preAlloc bool) WorkerOption {
+	return func(o *workerOptions) {
+		o.preAlloc = preAlloc
+	}
+}
+
+// WithNonblocking 消费池满时不阻塞提交，任务队列稍后重试提交，默认阻塞
+// 公平调度依赖阻塞提交控制顺序，开启公平调度时不建议使用
+func WithNonblocking(nonblocking bool) WorkerOption {
+	return func(o *workerOptions) {
+		o.nonblocking = nonblocking
+	}
+}
+
+// WithPanicHandler 消费池协程的 panic 回调，任务的 panic 已经由 PanicPolicy 处理，只会收到调度本身的 panic
+func WithPanicHandler(handler func(any)) WorkerOption {
+	return func(o *workerOptions) {
+		o.panicHandler = handler
+	}
+}
+
+// WithPoolLogger 消费池的日志
+func WithPoolLogger(l ants.Logger) WorkerOption {
+	return func(o *workerOptions) {
+		o.poolLogger = l
+	}
+}
+
+// WithLogger 工作队列的日志，记录提交失败、panic、慢任务和启停（Debug 级别）等事件，默认使用 logger.Default()
+func WithLogger(l logger.Logger) WorkerOption {
+	return func(o *workerOptions) {
+		o.logger = l
+	}
+}
+
+// WithTracerProvider 任务 span 使用的 tracer provider，默认使用 otel.GetTracerProvider()
+func WithTracerProvider(tp trace.TracerProvider) WorkerOption {
+	return func(o *workerOptions) {
+		o.tracerProvider = tp
+	}
+}
+
+func (o *workerOptions) antsOptions() []ants.Option {
+	opts := []ants.Option{
+		ants.WithPreAlloc(o.preAlloc),
+		ants.WithNonblocking(o.nonblocking),
+	}
+	if o.expiryDuration > 0 {
+		opts = append(opts, ants.WithExpiryDuration(o.expiryDuration))
+	}
+	if o.panicHandler != nil {
+		opts = append(opts, ants.WithPanicHandler(o.panicHandler))
+	}
+	if o.poolLogger != nil {
+		opts = append(opts, ants.WithLogger(o.poolLogger))
+	}
+
+	return opts
+}
@@ -0,0 +1,102 @@
+package jobs
+
+// Priority 任务优先级，同一个key的队列中优先级高的任务先执行，同一优先级内先进先出
+type Priority uint8
+
+const (
+	PriorityLow    Priority = iota // 低优先级
+	PriorityNormal                 // 默认优先级
+	PriorityHigh                   // 高优先级，例如踢人、落地等控制消息
+
+	priorityLevels = int(PriorityHigh) + 1
+)
+
+// WithPriority 设置任务优先级
+func WithPriority(priority Priority) DispatchOption {
+	return func(t *task) {
+		if priority > PriorityHigh {
+			priority = PriorityHigh
+		}
+		t.priority = priority
+	}
+}
+
+// 多级任务队列，每个优先级一个队列，非默认优先级的队列按需创建
+type priorityTaskQueue struct {
+	queueType QueueType
+	levels    [priorityLevels]taskQueue
+	size      int
+}
+
+func newPriorityTaskQueue(queueType QueueType) *priorityTaskQueue {
+	q := &priorityTaskQueue{queueType: queueType}
+	q.levels[PriorityNormal] = newTaskQueue(queueType)
+
+	return q
+}
+
+func (q *priorityTaskQueue) Enqueue(t *task) {
+	level := q.levels[t.priority]
+	if level == nil {
+		level = newTaskQueue(q.queueType)
+		q.levels[t.priority] = level
+	}
+
+	level.Enqueue(t)
+	q.size++
+}
+
+// Dequeue 从优先级最高的非空队列出队
+func (q *priorityTaskQueue) Dequeue() *task {
+	for i := priorityLevels - 1; i >= 0; i-- {
+		if level := q.levels[i]; level != nil && level.Size() > 0 {
+			q.size--
+			return level.Dequeue()
+		}
+	}
+
+	return nil
+}
+
+// DequeueLowest 从优先级最低的非空队列出队，队列溢出时优先丢弃低优先级的任务
+func (q *priorityTaskQueue) DequeueLowest() *task {
+	for i := 0; i < priorityLevels; i++ {
+		if level := q.levels[i]; level != nil && level.Size() > 0 {
+			q.size--
+			return level.Dequeue()
+		}
+	}
+
+	return nil
+}
+
+// Peek 返回下一个执行的任务
+func (q *priorityTaskQueue) Peek() *task {
+	for i := priorityLevels - 1; i >= 0; i-- {
+		if level := q.levels[i]; level != nil && level.Size() > 0 {
+			return level.Peek()
+		}
+	}
+
+	return nil
+}
+
+// Oldest 返回最早入队的任务，每个优先级内先进先出，只需要比较每个队列的队首
+func (q *priorityTaskQueue) Oldest() *task {
+	var oldest *task
+	for _, level := range q.levels {
+		if level == nil || level.Size() == 0 {
+			continue
+		}
+
+		if t := level.Peek(); oldest == nil || t.enqueuedAt.Before(oldest.enqueuedAt) {
+			oldest = t
+		}
+	}
+
+	return oldest
+}
+
+func (q *priorityTaskQueue) Size() int {
+	return q.size
+}
@@ -0,0 +1,143 @@
+package jobs
+
+import (
+	"sync"
+)
+
+// 任务队列的key，hash 模式下 raw 为空
+type providerKey struct {
+	hash uint64
+	raw  string
+}
+
+// 生产池分片，每个分片独立加锁
+type providerShard struct {
+	sync.Mutex
+	provider map[providerKey]*JobQueue
+	// 精确路由模式下每个hash key对应的原始key数量
+	hashKeys map[uint64]int
+}
+
+func newProviderShard() *providerShard {
+	return &providerShard{
+		provider: make(map[providerKey]*JobQueue),
+		hashKeys: make(map[uint64]int),
+	}
+}
+
+// 生产池，按照 hash key 分片，避免所有的投递竞争同一把锁
+type providerRegistry struct {
+	shards []*providerShard
+}
+
+// 分片数量，0 使用默认值
+func providerShardCount(shardCount int32) int32 {
+	if shardCount <= 0 {
+		return DefaultProviderShards
+	}
+
+	return shardCount
+}
+
+func newProviderRegistry(shardCount int32) *providerRegistry {
+	r := &providerRegistry{
+		shards: make([]*providerShard, providerShardCount(shardCount)),
+	}
+	for i := range r.shards {
+		r.shards[i] = newProviderShard()
+	}
+
+	return r
+}
+
+func (r *providerRegistry) shard(hash uint64) *providerShard {
+	return r.shards[hash%uint64(len(r.shards))]
+}
+
+// 获取任务队列，不存在时创建，collided 表示新建的队列和已有的原始key hash 冲突
+func (r *providerRegistry) fetch(pk providerKey, create func() *JobQueue) (queue *JobQueue, collided bool) {
+	shard := r.shard(pk.hash)
+	shard.Lock()
+	defer shard.Unlock()
+
+	queue, ok := shard.provider[pk]
+	if !ok {
+		queue = create()
+		shard.provider[pk] = queue
+
+		if pk.raw != "" {
+			collided = shard.hashKeys[pk.hash] > 0
+			shard.hashKeys[pk.hash]++
+		}
+	}
+
+	return queue, collided
+}
+
+// 遍历所有的任务队列，fn 返回 false 时停止遍历
+// 遍历时持有分片的锁，fn 中不能再访问生产池
+func (r *providerRegistry) each(fn func(queue *JobQueue) bool) {
+	for _, shard := range r.shards {
+		shard.Lock()
+		for _, queue := range shard.provider {
+			if !fn(queue) {
+				shard.Unlock()
+				return
+			}
+		}
+		shard.Unlock()
+	}
+}
+
+// 所有的任务队列
+func (r *providerRegistry) all() []*JobQueue {
+	var queues []*JobQueue
+	r.each(func(queue *JobQueue) bool {
+		queues = append(queues, queue)
+		return true
+	})
+
+	return queues
+}
+
+// 按分片清除空闲队列，每次只持有一个分片的锁
+func (r *providerRegistry) clearIdle() {
+	for _, shard := range r.shards {
+		shard.clearIdle()
+	}
+}
+
+func (s *providerShard) clearIdle() {
+	s.Lock()
+	defer s.Unlock()
+
+	for pk, queue := range s.provider {
+		// 获取队列和投递之间没有持有分片的锁，回收标记让正在投递的请求重新获取队列
+		if !queue.retire() {
+			continue
+		}
+
+		delete(s.provider, pk)
+		if pk.raw != "" {
+			if s.hashKeys[pk.hash]--; s.hashKeys[pk.hash] <= 0 {
+				delete(s.hashKeys, pk.hash)
+			}
+		}
+	}
+}
+
+// 清空所有的任务队列，返回被清空的队列
+func (r *providerRegistry) reset() []*JobQueue {
+	var queues []*JobQueue
+	for _, shard := range r.shards {
+		shard.Lock()
+		for _, queue := range shard.provider {
+			queues = append(queues, queue)
+		}
+		shard.provider = make(map[providerKey]*JobQueue)
+		shard.hashKeys = make(map[uint64]int)
+		shard.Unlock()
+	}
+
+	return queues
+}
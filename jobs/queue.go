@@ -36,3 +36,138 @@ func (q *Queue) IsEmpty() bool {
 func (q *Queue) Size() int {
 	return q.list.Len()
 }
+
+// 任务队列接口，JobQueue 持有锁后调用，实现不需要保证并发安全
+type taskQueue interface {
+	// 在队尾添加一个任务
+	Enqueue(t *task)
+	// 从队首移除一个任务并返回，队列为空时返回 nil
+	Dequeue() *task
+	// 返回队首的任务，不移除，队列为空时返回 nil
+	Peek() *task
+	// 队列的大小
+	Size() int
+}
+
+// 根据配置创建任务队列
+func newTaskQueue(queueType QueueType) taskQueue {
+	if queueType == QueueList {
+		return &listTaskQueue{queue: NewQueue()}
+	}
+
+	return &ringTaskQueue{}
+}
+
+// 基于 Queue 的任务队列
+type listTaskQueue struct {
+	queue *Queue
+}
+
+func (q *listTaskQueue) Enqueue(t *task) {
+	q.queue.Enqueue(t)
+}
+
+func (q *listTaskQueue) Dequeue() *task {
+	t := q.queue.Dequeue()
+	if t == nil {
+		return nil
+	}
+
+	return t.(*task)
+}
+
+func (q *listTaskQueue) Peek() *task {
+	if q.queue.IsEmpty() {
+		return nil
+	}
+
+	return q.queue.list.Front().Value.(*task)
+}
+
+func (q *listTaskQueue) Size() int {
+	return q.queue.Size()
+}
+
+// 每个分块的任务数
+const ringChunkSize = 64
+
+// 环形队列的分块
+type ringChunk struct {
+	tasks [ringChunkSize]*task
+	next  *ringChunk
+}
+
+// 分块的环形队列，每次分配一个分块，出队后的空分块留作备用，避免每个任务都分配链表节点
+type ringTaskQueue struct {
+	head  *ringChunk // 出队的分块
+	tail  *ringChunk // 入队的分块
+	read  int        // head 中下一个出队的位置
+	write int        // tail 中下一个入队的位置
+	size  int
+	spare *ringChunk // 备用的空分块
+}
+
+func (q *ringTaskQueue) Enqueue(t *task) {
+	if q.tail == nil {
+		q.head = q.newChunk()
+		q.tail = q.head
+		q.read, q.write = 0, 0
+	} else if q.write == ringChunkSize {
+		chunk := q.newChunk()
+		q.tail.next = chunk
+		q.tail = chunk
+		q.write = 0
+	}
+
+	q.tail.tasks[q.write] = t
+	q.write++
+	q.size++
+}
+
+func (q *ringTaskQueue) Dequeue() *task {
+	if q.size == 0 {
+		return nil
+	}
+
+	t := q.head.tasks[q.read]
+	q.head.tasks[q.read] = nil
+	q.read++
+	q.size--
+
+	if q.size == 0 {
+		// 队列为空，只保留一个分块从头开始写
+		q.read, q.write = 0, 0
+		q.head.next = nil
+		q.tail = q.head
+	} else if q.read == ringChunkSize {
+		chunk := q.head
+		q.head = chunk.next
+		q.read = 0
+
+		chunk.next = nil
+		q.spare = chunk
+	}
+
+	return t
+}
+
+func (q *ringTaskQueue) Peek() *task {
+	if q.size == 0 {
+		return nil
+	}
+
+	return q.head.tasks[q.read]
+}
+
+func (q *ringTaskQueue) Size() int {
+	return q.size
+}
+
+func (q *ringTaskQueue) newChunk() *ringChunk {
+	if chunk := q.spare; chunk != nil {
+		q.spare = nil
+		return chunk
+	}
+
+	return &ringChunk{}
+}
@@ -0,0 +1,160 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+
+	"pipeline/metrics"
+)
+
+// DefaultClass 没有指定分类的key使用的分类
+const DefaultClass = "default"
+
+// WithClass 设置key的分类，开启公平调度后按照分类的权重分配消费协程，同一个key以最后一次设置为准
+func WithClass(class string) DispatchOption {
+	return func(t *task) {
+		t.class = class
+	}
+}
+
+// 等待提交的任务队列
+type readyQueue struct {
+	queue   *JobQueue
+	readyAt time.Time
+}
+
+// 每个分类等待提交的任务队列
+type classQueue struct {
+	name    string
+	ready   []readyQueue
+	deficit int
+}
+
+// 公平调度器，按照分类的权重使用差额轮询(deficit round robin)把任务队列提交到消费池
+// 消费池满时只有调度器阻塞在提交上，空闲协程按照权重分配给各个分类，避免大量低权重的key挤占重要的key
+type fairScheduler struct {
+	mu      sync.Mutex
+	classes map[string]*classQueue
+	order   []*classQueue // 轮询顺序
+	next    int           // 当前轮询的分类
+	size    int           // 等待提交的任务队列总数
+
+	notify chan struct{}
+	stop   chan struct{}
+	once   sync.Once
+
+	weight func(class string) int
+	// 分类是否配置了权重，没有配置的分类在空闲时移除
+	configured func(class string) bool
+}
+
+func newFairScheduler(weight func(class string) int, configured func(class string) bool) *fairScheduler {
+	s := &fairScheduler{
+		classes:    make(map[string]*classQueue),
+		notify:     make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		weight:     weight,
+		configured: configured,
+	}
+
+	go s.run()
+	return s
+}
+
+// 任务队列需要消费协程，加入所属分类等待提交
+func (s *fairScheduler) enqueue(queue *JobQueue, class string) {
+	if class == "" {
+		class = DefaultClass
+	}
+
+	s.mu.Lock()
+	cq, ok := s.classes[class]
+	if !ok {
+		cq = &classQueue{name: class}
+		s.classes[class] = cq
+		s.order = append(s.order, cq)
+	}
+	cq.ready = append(cq.ready, readyQueue{queue: queue, readyAt: time.Now()})
+	s.size++
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// 按照差额轮询选出下一个提交的任务队列，每次提交消耗一个额度，额度用完或者没有等待的任务队列时轮到下一个分类
+func (s *fairScheduler) pick() (readyQueue, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size == 0 {
+		return readyQueue{}, "", false
+	}
+
+	for {
+		cq := s.order[s.next]
+		if len(cq.ready) == 0 {
+			cq.deficit = 0
+			if !s.configured(cq.name) {
+				s.remove(s.next)
+			} else {
+				s.next = (s.next + 1) % len(s.order)
+			}
+			continue
+		}
+
+		if cq.deficit <= 0 {
+			cq.deficit += s.weight(cq.name)
+		}
+
+		rq := cq.ready[0]
+		cq.ready[0] = readyQueue{}
+		cq.ready = cq.ready[1:]
+		cq.deficit--
+		s.size--
+
+		switch {
+		case len(cq.ready) == 0 && !s.configured(cq.name):
+			s.remove(s.next)
+		case cq.deficit <= 0 || len(cq.ready) == 0:
+			s.next = (s.next + 1) % len(s.order)
+		}
+
+		return rq, cq.name, true
+	}
+}
+
+// 移除空闲的分类，避免任意的分类名让轮询顺序无限增长，下一个分类移动到当前位置
+func (s *fairScheduler) remove(i int) {
+	delete(s.classes, s.order[i].name)
+	s.order[i] = nil
+	s.order = append(s.order[:i], s.order[i+1:]...)
+	if s.next >= len(s.order) {
+		s.next = 0
+	}
+}
+
+func (s *fairScheduler) run() {
+	for {
+		rq, class, ok := s.pick()
+		if !ok {
+			select {
+			case <-s.notify:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+
+		metrics.ReportClassWait(class, time.Since(rq.readyAt))
+		rq.queue.submitToPool()
+	}
+}
+
+func (s *fairScheduler) close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"sort"
+	"time"
+)
+
+// Snapshot 工作队列的快照
+type Snapshot struct {
+	// 快照时间
+	Time time.Time `json:"time"`
+	// 是否已经停止
+	Stopped bool `json:"stopped"`
+	// 消费池状态
+	Pool PoolSnapshot `json:"pool"`
+	// 所有key缓存的任务总数
+	Backlog int `json:"backlog"`
+	// 活跃的key，按照缓存的任务数从多到少排序
+	Keys []KeySnapshot `json:"keys"`
+}
+
+// PoolSnapshot 消费池状态
+type PoolSnapshot struct {
+	// 消费池大小
+	Cap int `json:"cap"`
+	// 运行中的协程数
+	Running int `json:"running"`
+	// 阻塞等待空闲协程的提交数
+	Waiting int `json:"waiting"`
+}
+
+// KeySnapshot 活跃的key，有缓存的任务、正在执行或者暂停
+type KeySnapshot struct {
+	// 队列的hash key
+	Key uint64 `json:"key"`
+	// 精确路由模式下的原始key
+	RawKey []byte `json:"raw_key,omitempty"`
+	// 公平调度的分类
+	Class string `json:"class,omitempty"`
+	// 缓存的任务数
+	Backlog int `json:"backlog"`
+	// 最早入队的任务已经等待的时间
+	OldestJobAge time.Duration `json:"oldest_job_age"`
+	// 是否有任务正在执行
+	Running bool `json:"running"`
+	// 当前任务已经执行的时间
+	RunningFor time.Duration `json:"running_for,omitempty"`
+	// 是否因为panic暂停
+	Paused bool `json:"paused"`
+}
+
+// Snapshot 获取工作队列的快照，每个key分别加锁读取，不会阻塞其他key的投递
+func (w *WorkerQueue) Snapshot() *Snapshot {
+	now := time.Now()
+	snapshot := &Snapshot{
+		Time:    now,
+		Stopped: w.stopped.Load(),
+		Pool: PoolSnapshot{
+			Cap:     w.consumer.Cap(),
+			Running: w.consumer.Running(),
+			Waiting: w.consumer.Waiting(),
+		},
+		Keys: []KeySnapshot{},
+	}
+
+	for _, queue := range w.providers() {
+		key, active := queue.snapshot(now)
+		if !active {
+			continue
+		}
+
+		snapshot.Backlog += key.Backlog
+		snapshot.Keys = append(snapshot.Keys, key)
+	}
+
+	sort.Slice(snapshot.Keys, func(i, j int) bool {
+		if snapshot.Keys[i].Backlog != snapshot.Keys[j].Backlog {
+			return snapshot.Keys[i].Backlog > snapshot.Keys[j].Backlog
+		}
+		return snapshot.Keys[i].OldestJobAge > snapshot.Keys[j].OldestJobAge
+	})
+
+	return snapshot
+}
+
+// 队列的快照，空闲的队列返回 false
+func (j *JobQueue) snapshot(now time.Time) (KeySnapshot, bool) {
+	j.Lock()
+	defer j.Unlock()
+
+	key := KeySnapshot{
+		Key:     j.key,
+		Class:   j.class,
+		Backlog: j.jobs.Size(),
+		Running: j.running.Load() != 0,
+		Paused:  j.paused,
+	}
+	if j.rawKey != "" {
+		key.RawKey = []byte(j.rawKey)
+	}
+	if oldest := j.jobs.Oldest(); oldest != nil {
+		key.OldestJobAge = now.Sub(oldest.enqueuedAt)
+	}
+	if since := j.runningSince.Load(); since != 0 {
+		key.RunningFor = now.Sub(time.Unix(0, since))
+	}
+
+	return key, key.Backlog > 0 || key.Running || key.Paused
+}
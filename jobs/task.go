@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"pipeline/metrics"
+)
+
+// ContextJob 携带上下文的回调函数，上下文在排队期间结束时任务会被跳过
+type ContextJob func(ctx context.Context) error
+
+// DispatchOption 任务投递和队列路由选项
+type DispatchOption func(t *task)
+
+// WithRawKey 携带序列化后的原始key，精确路由模式下按照原始key区分队列，hash key 只用于分片
+func WithRawKey(raw []byte) DispatchOption {
+	return func(t *task) {
+		t.rawKey = raw
+	}
+}
+
+// WithDropHandler 任务没有被执行就被丢弃时回调，err 为丢弃原因
+func WithDropHandler(fn func(err error)) DispatchOption {
+	return func(t *task) {
+		t.dropped = fn
+	}
+}
+
+// 从选项中获取原始key
+func rawKeyOf(opts []DispatchOption) []byte {
+	var t task
+	for _, opt := range opts {
+		opt(&t)
+	}
+
+	return t.rawKey
+}
+
+// 队列中的任务
+type task struct {
+	job    Job
+	ctx    context.Context
+	ctxJob ContextJob
+
+	// 任务被丢弃时的回调
+	dropped func(err error)
+	// 入队时间
+	enqueuedAt time.Time
+	// 原始key
+	rawKey []byte
+	// 优先级
+	priority Priority
+	// key的分类
+	class string
+	// 投递方的 span
+	spanContext trace.SpanContext
+}
+
+func newTask(f Job, opts []DispatchOption) *task {
+	t := &task{job: f, priority: PriorityNormal}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+func newContextTask(ctx context.Context, f ContextJob, opts []DispatchOption) *task {
+	t := &task{ctx: ctx, ctxJob: f, priority: PriorityNormal, spanContext: trace.SpanContextFromContext(ctx)}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// 执行任务，上下文已经结束的任务直接丢弃，返回 ContextJob 的错误
+func (t *task) run(key uint64, hashkey string) error {
+	if t.ctx != nil {
+		if err := t.ctx.Err(); err != nil {
+			metrics.ReportJobTimeout(key, hashkey)
+			t.drop(err)
+			return nil
+		}
+	}
+
+	start := time.Now()
+	metrics.ReportJobWait(key, hashkey, start.Sub(t.enqueuedAt))
+	defer func() {
+		metrics.ReportJobConsume(key, hashkey, int64(time.Since(start)))
+	}()
+
+	if t.ctx == nil {
+		t.job()
+		return nil
+	}
+
+	return t.ctxJob(t.ctx)
+}
+
+// 丢弃任务，通知投递方
+func (t *task) drop(err error) {
+	if t.dropped != nil {
+		t.dropped(err)
+	}
+}
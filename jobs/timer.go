@@ -0,0 +1,252 @@
+package jobs
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	timerPending int32 = iota // 等待到期
+	timerFired                // 已经到期投递
+	timerStopped              // 已经取消
+)
+
+// Timer 延迟任务和周期任务的句柄，可以取消还没有投递的任务
+type Timer struct {
+	// 队列的hash key
+	key uint64
+	// 到期后投递的任务
+	task *task
+	// 到期回调
+	fire func()
+	// 周期任务的间隔，0表示只执行一次
+	interval time.Duration
+	// 所在槽还需要转动的圈数
+	rounds int
+	state  atomic.Int32
+}
+
+func newTimer(key uint64, t *task, fire func()) *Timer {
+	return &Timer{key: key, task: t, fire: fire}
+}
+
+// Stop 取消还没有到期的任务，任务已经到期投递或者已经取消时返回 false
+// 周期任务取消后不再投递，已经在队列中的任务也不再执行
+func (t *Timer) Stop() bool {
+	return t.state.CompareAndSwap(timerPending, timerStopped)
+}
+
+// 是否已经取消
+func (t *Timer) stopped() bool {
+	return t.state.Load() == timerStopped
+}
+
+// 到期，没有被取消时执行回调，周期任务保持等待状态由回调重新加入时间轮
+func (t *Timer) expire() {
+	if t.interval > 0 {
+		if t.state.Load() == timerPending {
+			t.fire()
+		}
+		return
+	}
+
+	if t.state.CompareAndSwap(timerPending, timerFired) {
+		t.fire()
+	}
+}
+
+// 时间轮，所有延迟任务共用一个转动的协程，没有等待的任务时协程退出，到期的任务按key交给投递协程
+type timingWheel struct {
+	// 每个槽的时间跨度
+	tick  time.Duration
+	slots [][]*Timer
+
+	sync.Mutex
+	// 当前指向的槽
+	cursor int
+	// 等待到期的任务数，包括已经取消还没有清理的任务
+	count int
+	// 是否有协程在转动
+	running bool
+	// 开始转动的时间和已经转动的刻度，协程调度延迟时按照实际时间追赶
+	start time.Time
+	ticks int64
+	// 已经到期等待投递的任务 <key, timers>，每个key由独立的协程按照到期顺序投递
+	firing map[uint64][]*Timer
+	// 是否已经停止
+	stopped bool
+	done    chan struct{}
+}
+
+func newTimingWheel(tick time.Duration, slots int) *timingWheel {
+	return &timingWheel{
+		tick:   tick,
+		slots:  make([][]*Timer, slots),
+		firing: make(map[uint64][]*Timer),
+		done:   make(chan struct{}),
+	}
+}
+
+// 添加延迟任务，时间轮已经停止时返回 false
+func (tw *timingWheel) add(delay time.Duration, t *Timer) bool {
+	tw.Lock()
+	defer tw.Unlock()
+
+	if tw.stopped {
+		return false
+	}
+
+	if !tw.running {
+		tw.running = true
+		tw.start = time.Now()
+		tw.ticks = 0
+		go tw.run()
+	}
+
+	// 当前刻度已经过去的时间也要计算在内，保证不会提前到期
+	elapsed := time.Since(tw.start) - time.Duration(tw.ticks)*tw.tick
+	n := int((delay + elapsed + tw.tick - 1) / tw.tick)
+	if n < 1 {
+		n = 1
+	}
+
+	t.rounds = (n - 1) / len(tw.slots)
+	slot := (tw.cursor + n) % len(tw.slots)
+	tw.slots[slot] = append(tw.slots[slot], t)
+	tw.count++
+
+	return true
+}
+
+func (tw *timingWheel) run() {
+	ticker := time.NewTicker(tw.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tw.done:
+			return
+		case <-ticker.C:
+		}
+
+		due, running := tw.advance()
+		tw.fire(due)
+
+		if !running {
+			return
+		}
+	}
+}
+
+// 转动到当前时间，返回到期的任务，没有等待的任务时停止转动
+func (tw *timingWheel) advance() (due []*Timer, running bool) {
+	tw.Lock()
+	defer tw.Unlock()
+
+	if tw.stopped {
+		return nil, false
+	}
+
+	target := int64(time.Since(tw.start) / tw.tick)
+	for ; tw.ticks < target; tw.ticks++ {
+		tw.cursor = (tw.cursor + 1) % len(tw.slots)
+
+		slot := tw.slots[tw.cursor]
+		remain := slot[:0]
+		for _, t := range slot {
+			switch {
+			case t.state.Load() != timerPending:
+				tw.count--
+			case t.rounds > 0:
+				t.rounds--
+				remain = append(remain, t)
+			default:
+				tw.count--
+				due = append(due, t)
+			}
+		}
+
+		clear(slot[len(remain):])
+		tw.slots[tw.cursor] = remain
+	}
+
+	if tw.count == 0 {
+		tw.running = false
+		return due, false
+	}
+
+	return due, true
+}
+
+// 投递到期的任务，投递可能阻塞（OverflowBlock、消费池已满），不能在时间轮的协程中执行
+// 每个key的任务交给独立的协程按照到期顺序投递，一个key阻塞不会影响其他key
+func (tw *timingWheel) fire(due []*Timer) {
+	if len(due) == 0 {
+		return
+	}
+
+	tw.Lock()
+	defer tw.Unlock()
+
+	for _, t := range due {
+		queue, ok := tw.firing[t.key]
+		tw.firing[t.key] = append(queue, t)
+		if !ok {
+			go tw.drain(t.key)
+		}
+	}
+}
+
+// 依次投递key已经到期的任务，没有等待投递的任务时退出
+func (tw *timingWheel) drain(key uint64) {
+	for {
+		tw.Lock()
+		queue := tw.firing[key]
+		if len(queue) == 0 {
+			delete(tw.firing, key)
+			tw.Unlock()
+			return
+		}
+
+		t := queue[0]
+		queue[0] = nil
+		tw.firing[key] = queue[1:]
+		tw.Unlock()
+
+		t.expire()
+	}
+}
+
+// 停止时间轮，返回所有还没有投递的任务，包括已经到期等待投递的任务
+func (tw *timingWheel) stop() []*Timer {
+	tw.Lock()
+	defer tw.Unlock()
+
+	if tw.stopped {
+		return nil
+	}
+
+	tw.stopped = true
+	close(tw.done)
+
+	var pending []*Timer
+	for i, slot := range tw.slots {
+		for _, t := range slot {
+			if t.state.CompareAndSwap(timerPending, timerStopped) {
+				pending = append(pending, t)
+			}
+		}
+		tw.slots[i] = nil
+	}
+	for _, queue := range tw.firing {
+		for _, t := range queue {
+			if t.state.CompareAndSwap(timerPending, timerStopped) {
+				pending = append(pending, t)
+			}
+		}
+	}
+	tw.count = 0
+
+	return pending
+}
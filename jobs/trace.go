@@ -0,0 +1,55 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracerName 工作队列创建 tracer 使用的名字
+	TracerName = "pipeline/jobs"
+	// 每个任务的 span 名字
+	jobSpanName = "pipeline.job"
+)
+
+// WithTraceContext 携带投递方的 span，任务执行时作为父 span，PostContext 自动从上下文中获取
+func WithTraceContext(ctx context.Context) DispatchOption {
+	return func(t *task) {
+		t.spanContext = trace.SpanContextFromContext(ctx)
+	}
+}
+
+// 开始任务的 span，position 为任务在当前批次中的位置
+func (j *JobQueue) startSpan(t *task, position int32) trace.Span {
+	ctx := context.Background()
+	if t.spanContext.IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, t.spanContext)
+	}
+
+	_, span := j.Tracer().Start(ctx, jobSpanName,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("pipeline.key_hash", j.hashkey),
+			attribute.Int64("pipeline.queue_wait_us", time.Since(t.enqueuedAt).Microseconds()),
+			attribute.Int("pipeline.batch_position", int(position)),
+			attribute.Int("pipeline.priority", int(t.priority)),
+		))
+
+	return span
+}
+
+// 记录任务的错误
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// 记录任务的 panic
+func recordSpanPanic(span trace.Span, recovered any) {
+	recordSpanError(span, fmt.Errorf("job panic: %v", recovered))
+}
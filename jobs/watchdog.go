@@ -0,0 +1,115 @@
+package jobs
+
+import (
+	"sort"
+	"time"
+
+	"pipeline/metrics"
+	"pipeline/serial"
+)
+
+// StuckKey 执行时间超过 SlowJobThreshold 的队列
+type StuckKey struct {
+	// 队列的hash key
+	Key uint64
+	// 精确路由模式下的原始key
+	RawKey []byte
+	// 当前任务开始执行的时间
+	StartedAt time.Time
+	// 当前任务已经执行的时间
+	RunningFor time.Duration
+	// 发现卡住时执行任务的协程调用栈
+	Stack []byte
+}
+
+// StuckKeys 获取当前卡住的队列，按照执行时间从长到短排序
+func (w *WorkerQueue) StuckKeys() []StuckKey {
+	w.stuckMutex.Lock()
+	defer w.stuckMutex.Unlock()
+
+	keys := make([]StuckKey, 0, len(w.stuck))
+	for _, stuck := range w.stuck {
+		keys = append(keys, stuck)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].RunningFor > keys[j].RunningFor
+	})
+
+	return keys
+}
+
+func (w *WorkerQueue) onWatchdog() {
+	time.AfterFunc(w.watchdogInterval(), func() {
+		w.checkSlowJobs()
+
+		if !w.stopped.Load() {
+			w.onWatchdog()
+		}
+	})
+}
+
+// 检查间隔为慢任务阈值的一半，没有开启时使用最大间隔，等待 Reconfigure 开启
+func (w *WorkerQueue) watchdogInterval() time.Duration {
+	threshold := w.config().SlowJobThreshold
+	if threshold <= 0 {
+		return maxWatchdogInterval
+	}
+
+	interval := threshold / 2
+	if interval < minWatchdogInterval {
+		return minWatchdogInterval
+	}
+	if interval > maxWatchdogInterval {
+		return maxWatchdogInterval
+	}
+
+	return interval
+}
+
+// 检查执行时间超过阈值的任务，每个任务只在第一次发现时上报
+func (w *WorkerQueue) checkSlowJobs() {
+	threshold := w.config().SlowJobThreshold
+	stuck := make(map[*JobQueue]StuckKey)
+
+	if threshold > 0 {
+		w.stuckMutex.Lock()
+		prevStuck := w.stuck
+		w.stuckMutex.Unlock()
+
+		now := time.Now()
+		for _, queue := range w.providers() {
+			since := queue.runningSince.Load()
+			if since == 0 {
+				continue
+			}
+
+			startedAt := time.Unix(0, since)
+			if now.Sub(startedAt) < threshold {
+				continue
+			}
+
+			if prev, ok := prevStuck[queue]; ok && prev.StartedAt.Equal(startedAt) {
+				prev.RunningFor = now.Sub(startedAt)
+				stuck[queue] = prev
+				continue
+			}
+
+			key := StuckKey{
+				Key:        queue.key,
+				RawKey:     []byte(queue.rawKey),
+				StartedAt:  startedAt,
+				RunningFor: now.Sub(startedAt),
+				Stack:      serial.GoroutineStack(queue.running.Load()),
+			}
+			stuck[queue] = key
+
+			metrics.ReportJobTimeout(queue.key, queue.hashkey)
+			w.Logger().Warn("slow job", "key", key.Key, "running_for", key.RunningFor, "stack", string(key.Stack))
+		}
+	}
+
+	w.stuckMutex.Lock()
+	w.stuck = stuck
+	w.stuckMutex.Unlock()
+}
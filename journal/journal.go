@@ -0,0 +1,354 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"pipeline/dispatcher"
+)
+
+// ErrClosed 日志已经关闭
+var ErrClosed = errors.New("journal is closed")
+
+// Handler 处理消息，重放时同一条消息可能执行多次，需要保证幂等
+type Handler[K any] func(ctx context.Context, key K, msg []byte) error
+
+// Journal 持久化的任务日志
+// 投递的消息先追加到本地分段文件再进入key的队列，执行完成后写入确认
+// 重启时按照原始顺序重放没有确认的消息，同一个key的消息顺序不变
+type Journal[K any] struct {
+	dir        string
+	serial     dispatcher.Serializer[K]
+	dispatcher *dispatcher.PipelineDispatcher[K]
+	handler    Handler[K]
+	options    options
+
+	// 每个key的投递锁 <key, lock>，追加和投递在同一个key的锁中，保证重放顺序和执行顺序一致
+	keyMu    sync.Mutex
+	keyLocks map[string]*keyLock
+
+	mu     sync.Mutex
+	closed bool
+	// 最后一条记录的序号
+	seq uint64
+	// 正在写入的分段
+	active     *os.File
+	activeSize int64
+	// 所有的分段，从旧到新排序，最后一个是正在写入的分段
+	segments []uint64
+	// 每个分段中还没有确认的消息数
+	unacked map[uint64]int
+	// 没有确认的消息所在的分段 <seq, segment>
+	pending map[uint64]uint64
+}
+
+// Open 打开日志目录，重放上次没有确认的消息，之后通过 Post 投递新的消息
+// serial 用于持久化key，需要和分发器使用相同的序列化方式
+func Open[K any](dir string, serial dispatcher.Serializer[K], d *dispatcher.PipelineDispatcher[K], handler Handler[K], opts ...Option) (*Journal[K], error) {
+	if serial == nil || d == nil || handler == nil {
+		return nil, fmt.Errorf("journal serializer, dispatcher or handler is nil")
+	}
+
+	o := options{segmentSize: DefaultSegmentSize, sync: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.segmentSize <= 0 {
+		return nil, fmt.Errorf("journal segment size %d is invalid", o.segmentSize)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	j := &Journal[K]{
+		dir:        dir,
+		serial:     serial,
+		dispatcher: d,
+		handler:    handler,
+		options:    o,
+		keyLocks:   make(map[string]*keyLock),
+		unacked:    make(map[uint64]int),
+		pending:    make(map[uint64]uint64),
+	}
+
+	records, err := j.recover()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := j.roll(); err != nil {
+		return nil, err
+	}
+
+	if err := j.replay(records); err != nil {
+		j.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Post 持久化消息后投递到key的队列，执行完成后确认
+// 投递失败时直接确认并返回错误，不会在重启时重放
+// 同一个key的追加和投递串行执行，进入队列的顺序和追加的顺序一致，一个key的队列已满阻塞时不影响其他key
+func (j *Journal[K]) Post(key K, msg []byte) error {
+	raw, err := j.serial.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	unlock := j.lockKey(string(raw))
+	defer unlock()
+
+	seq, err := j.append(raw, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := j.post(seq, key, msg); err != nil {
+		j.ack(seq)
+		return err
+	}
+
+	return nil
+}
+
+// 投递锁，没有投递时释放
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// 获取key的投递锁，返回解锁函数
+func (j *Journal[K]) lockKey(key string) func() {
+	j.keyMu.Lock()
+	l, ok := j.keyLocks[key]
+	if !ok {
+		l = &keyLock{}
+		j.keyLocks[key] = l
+	}
+	l.refs++
+	j.keyMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		j.keyMu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(j.keyLocks, key)
+		}
+		j.keyMu.Unlock()
+	}
+}
+
+// Pending 还没有确认的消息数
+func (j *Journal[K]) Pending() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.pending)
+}
+
+// Close 关闭日志，队列中还没有执行的消息在下次打开时重放
+func (j *Journal[K]) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil
+	}
+
+	j.closed = true
+	return j.active.Close()
+}
+
+func (j *Journal[K]) post(seq uint64, key K, msg []byte) error {
+	return j.dispatcher.PostContext(context.Background(), key, func(ctx context.Context) error {
+		// 返回错误和 panic 也确认，否则所在的分段在进程退出前都不能删除，只有进程退出时没有确认的消息会重放
+		defer j.ack(seq)
+		return j.handler(ctx, key, msg)
+	})
+}
+
+// 读取所有分段，返回没有确认的消息，按照序号排序
+func (j *Journal[K]) recover() ([]*record, error) {
+	ids, err := listSegments(j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	posts := make(map[uint64]*record)
+	for _, id := range ids {
+		err := readSegment(segmentPath(j.dir, id), func(r *record) {
+			if r.seq > j.seq {
+				j.seq = r.seq
+			}
+
+			switch r.kind {
+			case recordPost:
+				posts[r.seq] = r
+				j.pending[r.seq] = id
+				j.unacked[id]++
+			case recordAck:
+				// 所在的分段已经删除的消息直接忽略
+				if segment, ok := j.pending[r.seq]; ok {
+					delete(posts, r.seq)
+					delete(j.pending, r.seq)
+					j.unacked[segment]--
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	j.segments = ids
+
+	records := make([]*record, 0, len(posts))
+	for _, r := range posts {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(a, b int) bool {
+		return records[a].seq < records[b].seq
+	})
+
+	return records, nil
+}
+
+// 按照原始顺序重放，同一个key的消息依次进入队列
+func (j *Journal[K]) replay(records []*record) error {
+	for _, r := range records {
+		key, err := j.serial.Unmarshal(r.key)
+		if err != nil {
+			return fmt.Errorf("journal replay %d key error %w", r.seq, err)
+		}
+
+		if err := j.post(r.seq, key, r.msg); err != nil {
+			return fmt.Errorf("journal replay %d error %w", r.seq, err)
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.compact()
+	return nil
+}
+
+// 追加消息，返回消息的序号
+func (j *Journal[K]) append(key []byte, msg []byte) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return 0, ErrClosed
+	}
+
+	if j.activeSize >= j.options.segmentSize {
+		if err := j.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	r := &record{kind: recordPost, seq: j.seq + 1, key: key, msg: msg}
+	if size := r.size(); size > maxRecordSize {
+		return 0, fmt.Errorf("journal record size %d exceeds %d", size, maxRecordSize)
+	}
+
+	seq := r.seq
+	if err := j.write(r, j.options.sync); err != nil {
+		return 0, err
+	}
+
+	j.seq = seq
+	segment := j.segments[len(j.segments)-1]
+	j.pending[seq] = segment
+	j.unacked[segment]++
+
+	return seq, nil
+}
+
+// 确认消息执行完成，确认不需要落盘，进程退出时丢失的确认会导致重放
+func (j *Journal[K]) ack(seq uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	segment, ok := j.pending[seq]
+	if !ok || j.closed {
+		return
+	}
+
+	if err := j.write(&record{kind: recordAck, seq: seq}, false); err != nil {
+		return
+	}
+
+	delete(j.pending, seq)
+	j.unacked[segment]--
+	j.compact()
+}
+
+// 写入记录，失败时丢弃写了一半的数据，避免之后的记录追加在损坏的记录后面，重启时无法读取
+func (j *Journal[K]) write(r *record, sync bool) error {
+	data := r.encode()
+	_, err := j.active.Write(data)
+	if err == nil && sync {
+		err = j.active.Sync()
+	}
+	if err != nil {
+		j.discard()
+		return err
+	}
+
+	j.activeSize += int64(len(data))
+	return nil
+}
+
+// 截断到最后一条完整的记录，截断失败时写入新的分段
+func (j *Journal[K]) discard() {
+	if err := j.active.Truncate(j.activeSize); err == nil {
+		return
+	}
+
+	j.roll()
+}
+
+// 创建新的分段
+func (j *Journal[K]) roll() error {
+	id := uint64(1)
+	if len(j.segments) > 0 {
+		id = j.segments[len(j.segments)-1] + 1
+	}
+
+	file, err := os.OpenFile(segmentPath(j.dir, id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if j.active != nil {
+		j.active.Close()
+	}
+	j.active = file
+	j.activeSize = 0
+	j.segments = append(j.segments, id)
+	j.compact()
+
+	return nil
+}
+
+// 从旧到新删除消息都已经确认的分段
+// 确认记录写在之后的分段中，只有之前的分段都删除后才能删除，否则重启时确认丢失
+func (j *Journal[K]) compact() {
+	for len(j.segments) > 1 && j.unacked[j.segments[0]] == 0 {
+		id := j.segments[0]
+		if err := os.Remove(segmentPath(j.dir, id)); err != nil && !os.IsNotExist(err) {
+			return
+		}
+
+		delete(j.unacked, id)
+		j.segments = j.segments[1:]
+	}
+}
@@ -0,0 +1,311 @@
+package journal
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"pipeline/dispatcher"
+	"pipeline/jobs"
+	"pipeline/serial"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	result map[uint64][]string
+}
+
+func (r *recorder) handle(ctx context.Context, key uint64, msg []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result[key] = append(r.result[key], string(msg))
+	return nil
+}
+
+func (r *recorder) get(key uint64) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.result[key]...)
+}
+
+func newDispatcher() *dispatcher.PipelineDispatcher[uint64] {
+	workQueue, err := jobs.NewWorkQueue(jobs.GetDefaultConfig())
+	if err != nil {
+		panic(err)
+	}
+
+	return dispatcher.NewDispatcher(&serial.Uint64Serializer{}, workQueue)
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	// 第一个进程，key 1 的队列被阻塞，消息没有执行
+	block := make(chan struct{})
+	defer close(block)
+	first := newDispatcher()
+	first.Post(1, func() { <-block })
+
+	r := &recorder{result: map[uint64][]string{}}
+	j, err := Open(dir, &serial.Uint64Serializer{}, first, r.handle)
+	if err != nil {
+		t.Fatalf("open journal error %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		j.Post(1, []byte(fmt.Sprint("a", i)))
+		j.Post(2, []byte(fmt.Sprint("b", i)))
+	}
+	for i := 0; i < 100 && len(r.get(2)) < 5; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 100 && j.Pending() != 5; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if pending := j.Pending(); pending != 5 {
+		t.Fatalf("expected %v pending, got %v", 5, pending)
+	}
+	// 模拟崩溃
+	j.Close()
+
+	// 重启后按照原始顺序重放没有确认的消息
+	replayed := &recorder{result: map[uint64][]string{}}
+	j, err = Open(dir, &serial.Uint64Serializer{}, newDispatcher(), replayed.handle)
+	if err != nil {
+		t.Fatalf("reopen journal error %v", err)
+	}
+	defer j.Close()
+
+	for i := 0; i < 100 && j.Pending() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if fmt.Sprint(replayed.get(1)) != "[a0 a1 a2 a3 a4]" {
+		t.Fatalf("expected %v, got %v", "[a0 a1 a2 a3 a4]", replayed.get(1))
+	}
+	if len(replayed.get(2)) != 0 {
+		t.Fatalf("expected acked messages not replayed, got %v", replayed.get(2))
+	}
+}
+
+func TestJournalCompact(t *testing.T) {
+	dir := t.TempDir()
+
+	r := &recorder{result: map[uint64][]string{}}
+	j, err := Open(dir, &serial.Uint64Serializer{}, newDispatcher(), r.handle, WithSegmentSize(64), WithSync(false))
+	if err != nil {
+		t.Fatalf("open journal error %v", err)
+	}
+	defer j.Close()
+
+	for i := 0; i < 100; i++ {
+		j.Post(uint64(i%3), []byte(fmt.Sprint(i)))
+	}
+	for i := 0; i < 100 && j.Pending() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 写入新的消息时切换分段，之前的分段都已经确认，全部删除
+	j.Post(0, []byte("last"))
+	for i := 0; i < 100 && j.Pending() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) > 2 {
+		t.Fatalf("expected acked segments removed, got %v segments", len(entries))
+	}
+}
+
+func TestJournalTornWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	block := make(chan struct{})
+	defer close(block)
+	d := newDispatcher()
+	d.Post(1, func() { <-block })
+
+	r := &recorder{result: map[uint64][]string{}}
+	j, err := Open(dir, &serial.Uint64Serializer{}, d, r.handle)
+	if err != nil {
+		t.Fatalf("open journal error %v", err)
+	}
+	j.Post(1, []byte("ok"))
+	j.Close()
+
+	// 写了一半的记录被丢弃
+	file, _ := os.OpenFile(segmentPath(dir, 1), os.O_WRONLY|os.O_APPEND, 0o644)
+	file.Write((&record{kind: recordPost, seq: 2, key: []byte{1}, msg: []byte("torn")}).encode()[:12])
+	file.Close()
+
+	// 长度损坏的记录不会按照长度分配内存
+	corrupted := (&record{kind: recordPost, seq: 2, key: []byte{1}, msg: []byte("corrupted")}).encode()
+	binary.BigEndian.PutUint32(corrupted[0:4], math.MaxUint32)
+	os.WriteFile(segmentPath(dir, 2), corrupted, 0o644)
+
+	replayed := &recorder{result: map[uint64][]string{}}
+	j, err = Open(dir, &serial.Uint64Serializer{}, newDispatcher(), replayed.handle)
+	if err != nil {
+		t.Fatalf("reopen journal error %v", err)
+	}
+	defer j.Close()
+
+	for i := 0; i < 100 && j.Pending() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if fmt.Sprint(replayed.get(1)) != "[ok]" {
+		t.Fatalf("expected %v, got %v", "[ok]", replayed.get(1))
+	}
+}
+
+func TestJournalBlockedKey(t *testing.T) {
+	cfg := jobs.GetDefaultConfig()
+	cfg.MaxJobsPerKey = 1
+	workQueue, err := jobs.NewWorkQueue(cfg)
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+	d := dispatcher.NewDispatcher(&serial.Uint64Serializer{}, workQueue)
+
+	block := make(chan struct{})
+	defer close(block)
+	d.Post(1, func() { <-block })
+
+	r := &recorder{result: map[uint64][]string{}}
+	j, err := Open(t.TempDir(), &serial.Uint64Serializer{}, d, r.handle, WithSync(false))
+	if err != nil {
+		t.Fatalf("open journal error %v", err)
+	}
+	defer j.Close()
+
+	// key 1 的队列已满，投递阻塞，不能影响其他key
+	j.Post(1, []byte("queued"))
+	go j.Post(1, []byte("blocked"))
+	time.Sleep(time.Millisecond * 10)
+
+	posted := make(chan error, 1)
+	go func() {
+		posted <- j.Post(2, []byte("ok"))
+	}()
+	select {
+	case err := <-posted:
+		if err != nil {
+			t.Fatalf("post error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("post of key 2 blocked by full key 1")
+	}
+}
+
+func TestJournalConcurrentOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	block := make(chan struct{})
+	d := newDispatcher()
+	d.Post(1, func() { <-block })
+
+	executed := &recorder{result: map[uint64][]string{}}
+	j, err := Open(dir, &serial.Uint64Serializer{}, d, executed.handle, WithSync(false))
+	if err != nil {
+		t.Fatalf("open journal error %v", err)
+	}
+
+	// 同一个key并发投递，进入队列的顺序和追加的顺序一致
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			j.Post(1, []byte(fmt.Sprint(i)))
+		}(i)
+	}
+	wg.Wait()
+	j.Close()
+
+	close(block)
+	for i := 0; i < 100 && len(executed.get(1)) != 50; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	replayed := &recorder{result: map[uint64][]string{}}
+	j, err = Open(dir, &serial.Uint64Serializer{}, newDispatcher(), replayed.handle)
+	if err != nil {
+		t.Fatalf("reopen journal error %v", err)
+	}
+	defer j.Close()
+
+	for i := 0; i < 100 && j.Pending() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if fmt.Sprint(replayed.get(1)) != fmt.Sprint(executed.get(1)) {
+		t.Fatalf("expected replay order %v, got %v", executed.get(1), replayed.get(1))
+	}
+}
+
+func TestJournalWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	block := make(chan struct{})
+	defer close(block)
+	d := newDispatcher()
+	d.Post(1, func() { <-block })
+
+	r := &recorder{result: map[uint64][]string{}}
+	j, err := Open(dir, &serial.Uint64Serializer{}, d, r.handle)
+	if err != nil {
+		t.Fatalf("open journal error %v", err)
+	}
+	j.Post(1, []byte("a"))
+
+	// 写了一半失败的记录被截断，之后的记录在重启时仍然可以读取
+	j.mu.Lock()
+	j.active.Write((&record{kind: recordPost, seq: 100, key: []byte{1}, msg: []byte("torn")}).encode()[:12])
+	j.discard()
+	j.mu.Unlock()
+	j.Post(1, []byte("b"))
+	j.Close()
+
+	replayed := &recorder{result: map[uint64][]string{}}
+	j, err = Open(dir, &serial.Uint64Serializer{}, newDispatcher(), replayed.handle)
+	if err != nil {
+		t.Fatalf("reopen journal error %v", err)
+	}
+	defer j.Close()
+
+	for i := 0; i < 100 && j.Pending() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if fmt.Sprint(replayed.get(1)) != "[a b]" {
+		t.Fatalf("expected %v, got %v", "[a b]", replayed.get(1))
+	}
+}
+
+func TestJournalHandlerPanic(t *testing.T) {
+	cfg := jobs.GetDefaultConfig()
+	cfg.OnPanic = func(key uint64, recovered any, stack []byte) {}
+	workQueue, err := jobs.NewWorkQueue(cfg)
+	if err != nil {
+		t.Fatalf("new work queue error %v", err)
+	}
+	d := dispatcher.NewDispatcher(&serial.Uint64Serializer{}, workQueue)
+
+	j, err := Open(t.TempDir(), &serial.Uint64Serializer{}, d, func(ctx context.Context, key uint64, msg []byte) error {
+		panic("this is a test panic")
+	}, WithSync(false))
+	if err != nil {
+		t.Fatalf("open journal error %v", err)
+	}
+	defer j.Close()
+
+	// panic 的消息也会确认，不会一直占用分段
+	j.Post(1, []byte("a"))
+	for i := 0; i < 100 && j.Pending() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if pending := j.Pending(); pending != 0 {
+		t.Fatalf("expected pending %v, got %v", 0, pending)
+	}
+}
@@ -0,0 +1,27 @@
+package journal
+
+const (
+	DefaultSegmentSize = 64 << 20 // 默认每个分段文件64MB
+)
+
+type options struct {
+	segmentSize int64
+	sync        bool
+}
+
+// Option 日志配置
+type Option func(o *options)
+
+// WithSegmentSize 分段文件的大小，超过后写入新的分段，消息都已经确认的分段会被删除
+func WithSegmentSize(size int64) Option {
+	return func(o *options) {
+		o.segmentSize = size
+	}
+}
+
+// WithSync 每条消息追加后是否落盘，默认开启，关闭后进程崩溃不丢失，机器掉电可能丢失
+func WithSync(sync bool) Option {
+	return func(o *options) {
+		o.sync = sync
+	}
+}
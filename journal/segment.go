@@ -0,0 +1,167 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	recordPost byte = 1 // 投递的消息
+	recordAck  byte = 2 // 消息执行完成的确认
+
+	recordHeaderSize = 8        // 长度和校验和
+	maxRecordSize    = 64 << 20 // 单条记录的最大长度，超过时视为损坏的记录
+	segmentSuffix    = ".wal"
+)
+
+// 日志记录
+// | length uint32 | crc32 uint32 | kind byte | seq uvarint | key length uvarint | key | msg |
+// 确认记录只有 kind 和 seq
+type record struct {
+	kind byte
+	seq  uint64
+	key  []byte
+	msg  []byte
+}
+
+// 编码后 payload 的长度
+func (r *record) size() int {
+	var buf [binary.MaxVarintLen64]byte
+	size := 1 + len(binary.AppendUvarint(buf[:0], r.seq))
+	if r.kind == recordPost {
+		size += len(binary.AppendUvarint(buf[:0], uint64(len(r.key)))) + len(r.key) + len(r.msg)
+	}
+
+	return size
+}
+
+func (r *record) encode() []byte {
+	payload := make([]byte, 0, r.size())
+	payload = append(payload, r.kind)
+	payload = binary.AppendUvarint(payload, r.seq)
+	if r.kind == recordPost {
+		payload = binary.AppendUvarint(payload, uint64(len(r.key)))
+		payload = append(payload, r.key...)
+		payload = append(payload, r.msg...)
+	}
+
+	buf := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	return append(buf, payload...)
+}
+
+func decodeRecord(payload []byte) (*record, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("journal record is empty")
+	}
+
+	r := &record{kind: payload[0]}
+	seq, n := binary.Uvarint(payload[1:])
+	if n <= 0 {
+		return nil, fmt.Errorf("journal record seq is invalid")
+	}
+	r.seq = seq
+	payload = payload[1+n:]
+
+	switch r.kind {
+	case recordAck:
+		return r, nil
+	case recordPost:
+		keyLen, n := binary.Uvarint(payload)
+		if n <= 0 || keyLen > uint64(len(payload)-n) {
+			return nil, fmt.Errorf("journal record key is invalid")
+		}
+		payload = payload[n:]
+		r.key = payload[:keyLen]
+		r.msg = payload[keyLen:]
+		return r, nil
+	default:
+		return nil, fmt.Errorf("journal record kind %d is invalid", r.kind)
+	}
+}
+
+// 读取分段中的所有记录，遇到写了一半或者校验失败的记录时停止，之后的记录丢弃
+func readSegment(path string, fn func(r *record)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+
+		// 长度损坏时不能按照长度分配内存，和校验失败一样丢弃之后的记录
+		length := binary.BigEndian.Uint32(header[0:4])
+		if length > maxRecordSize {
+			return nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			return nil
+		}
+
+		r, err := decodeRecord(payload)
+		if err != nil {
+			return nil
+		}
+		fn(r)
+	}
+}
+
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%016x%s", id, segmentSuffix))
+}
+
+// 目录中所有分段的id，从旧到新排序
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids, nil
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Logger 结构化日志接口，args 为 key value 交替的字段，和 slog 一致
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+	// With 返回携带固定字段的日志
+	With(args ...any) Logger
+}
+
+// slogLogger slog 适配，l 为 nil 时使用 slog.Default()，跟随 slog.SetDefault 的设置
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlog 使用 slog.Handler 输出日志
+func NewSlog(h slog.Handler) Logger {
+	return slogLogger{l: slog.New(h)}
+}
+
+func (s slogLogger) logger() *slog.Logger {
+	if s.l == nil {
+		return slog.Default()
+	}
+
+	return s.l
+}
+
+func (s slogLogger) Debug(msg string, args ...any) {
+	s.logger().Log(context.Background(), slog.LevelDebug, msg, args...)
+}
+
+func (s slogLogger) Info(msg string, args ...any) {
+	s.logger().Log(context.Background(), slog.LevelInfo, msg, args...)
+}
+
+func (s slogLogger) Warn(msg string, args ...any) {
+	s.logger().Log(context.Background(), slog.LevelWarn, msg, args...)
+}
+
+func (s slogLogger) Error(msg string, args ...any) {
+	s.logger().Log(context.Background(), slog.LevelError, msg, args...)
+}
+
+func (s slogLogger) With(args ...any) Logger {
+	return slogLogger{l: s.logger().With(args...)}
+}
+
+// Nop 不输出任何日志
+type Nop struct{}
+
+func (Nop) Debug(msg string, args ...any) {}
+func (Nop) Info(msg string, args ...any)  {}
+func (Nop) Warn(msg string, args ...any)  {}
+func (Nop) Error(msg string, args ...any) {}
+func (n Nop) With(args ...any) Logger     { return n }
+
+type loggerHolder struct {
+	Logger
+}
+
+var defaultLogger atomic.Pointer[loggerHolder]
+
+func init() {
+	SetDefault(nil)
+}
+
+// SetDefault 设置默认日志，没有单独配置日志的工作队列和 serial 包使用，nil 表示使用 slog.Default()
+func SetDefault(l Logger) {
+	if l == nil {
+		l = slogLogger{}
+	}
+
+	defaultLogger.Store(&loggerHolder{l})
+}
+
+// Default 获取默认日志
+func Default() Logger {
+	return defaultLogger.Load().Logger
+}
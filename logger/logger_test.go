@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewSlog(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	l.Debug("hidden")
+	l.With("key", 1).Error("job error", "queue_size", 3)
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("expected debug log filtered, got %v", out)
+	}
+	if !strings.Contains(out, "level=ERROR") || !strings.Contains(out, `msg="job error" key=1 queue_size=3`) {
+		t.Fatalf("unexpected log %v", out)
+	}
+}
+
+func TestDefaultLogger(t *testing.T) {
+	defer SetDefault(nil)
+
+	buf := &bytes.Buffer{}
+	SetDefault(NewSlog(slog.NewTextHandler(buf, nil)))
+	Default().Info("started")
+	if !strings.Contains(buf.String(), "msg=started") {
+		t.Fatalf("unexpected log %v", buf.String())
+	}
+
+	SetDefault(Nop{})
+	Default().Error("dropped")
+	if strings.Contains(buf.String(), "dropped") {
+		t.Fatalf("expected nop logger, got %v", buf.String())
+	}
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryReporter 内存中的指标统计，用于测试
+type MemoryReporter struct {
+	mu sync.Mutex
+
+	jobCount     map[uint64]int64
+	poolSize     int64
+	blockingSize int64
+	submits      []time.Duration
+	jobWaits     map[uint64][]time.Duration
+	jobConsumes  map[uint64][]time.Duration
+	timeouts     map[uint64]int
+	overflows    map[string]int
+	collisions   map[uint64]int
+	classWaits   map[string][]time.Duration
+}
+
+// NewMemoryReporter 创建内存指标统计
+func NewMemoryReporter() *MemoryReporter {
+	return &MemoryReporter{
+		jobCount:    make(map[uint64]int64),
+		jobWaits:    make(map[uint64][]time.Duration),
+		jobConsumes: make(map[uint64][]time.Duration),
+		timeouts:    make(map[uint64]int),
+		overflows:   make(map[string]int),
+		collisions:  make(map[uint64]int),
+		classWaits:  make(map[string][]time.Duration),
+	}
+}
+
+func (m *MemoryReporter) ReportJobCount(jobid uint64, count int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobCount[jobid] = count
+}
+
+func (m *MemoryReporter) ReportPoolSize(poolSize int64, blockingSize int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.poolSize = poolSize
+	m.blockingSize = blockingSize
+}
+
+func (m *MemoryReporter) ReportSubmitConsume(consume time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.submits = append(m.submits, consume)
+}
+
+func (m *MemoryReporter) ReportJobWait(jobid uint64, hashkey string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobWaits[jobid] = append(m.jobWaits[jobid], duration)
+}
+
+func (m *MemoryReporter) ReportJobConsume(jobid uint64, hashkey string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobConsumes[jobid] = append(m.jobConsumes[jobid], duration)
+}
+
+func (m *MemoryReporter) ReportJobTimeout(jobid uint64, hashkey string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeouts[jobid]++
+}
+
+func (m *MemoryReporter) ReportJobOverflow(jobid uint64, policy string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overflows[policy]++
+}
+
+func (m *MemoryReporter) ReportKeyCollision(jobid uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collisions[jobid]++
+}
+
+func (m *MemoryReporter) ReportClassWait(class string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.classWaits[class] = append(m.classWaits[class], duration)
+}
+
+// JobCount 最近一次上报的任务队列长度
+func (m *MemoryReporter) JobCount(jobid uint64) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jobCount[jobid]
+}
+
+// PoolSize 最近一次上报的工作池大小
+func (m *MemoryReporter) PoolSize() (poolSize int64, blockingSize int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.poolSize, m.blockingSize
+}
+
+// SubmitConsumes 所有的提交耗时
+func (m *MemoryReporter) SubmitConsumes() []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]time.Duration(nil), m.submits...)
+}
+
+// JobWaits 任务的排队等待时间
+func (m *MemoryReporter) JobWaits(jobid uint64) []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]time.Duration(nil), m.jobWaits[jobid]...)
+}
+
+// JobConsumes 任务的执行时间
+func (m *MemoryReporter) JobConsumes(jobid uint64) []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]time.Duration(nil), m.jobConsumes[jobid]...)
+}
+
+// JobTimeouts 任务超时次数
+func (m *MemoryReporter) JobTimeouts(jobid uint64) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.timeouts[jobid]
+}
+
+// JobOverflows 按照溢出策略统计的溢出次数
+func (m *MemoryReporter) JobOverflows(policy string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.overflows[policy]
+}
+
+// KeyCollisions key hash 冲突次数
+func (m *MemoryReporter) KeyCollisions(jobid uint64) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.collisions[jobid]
+}
+
+// ClassWaits 分类的等待时间
+func (m *MemoryReporter) ClassWaits(class string) []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]time.Duration(nil), m.classWaits[class]...)
+}
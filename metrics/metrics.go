@@ -1,28 +1,112 @@
 package metrics
 
+import (
+	"sync/atomic"
+	"time"
+)
+
 const metricName = "pipeline"
 
+// Reporter 指标上报接口，实现时可以嵌入 NopReporter，只实现关心的指标
+type Reporter interface {
+	// 任务队列长度
+	ReportJobCount(jobid uint64, count int64)
+	// 工作池运行中和阻塞等待的协程数
+	ReportPoolSize(poolSize int64, blockingSize int64)
+	// 任务队列提交到工作池的耗时
+	ReportSubmitConsume(consume time.Duration)
+	// 任务在队列中等待的时间
+	ReportJobWait(jobid uint64, hashkey string, duration time.Duration)
+	// 任务执行的时间
+	ReportJobConsume(jobid uint64, hashkey string, duration time.Duration)
+	// 任务超时
+	ReportJobTimeout(jobid uint64, hashkey string)
+	// 任务队列溢出
+	ReportJobOverflow(jobid uint64, policy string)
+	// 不同的key hash 冲突
+	ReportKeyCollision(jobid uint64)
+	// 公平调度时分类中的任务队列等待提交的时间
+	ReportClassWait(class string, duration time.Duration)
+}
+
+// NopReporter 不上报任何指标
+type NopReporter struct{}
+
+func (NopReporter) ReportJobCount(jobid uint64, count int64)                              {}
+func (NopReporter) ReportPoolSize(poolSize int64, blockingSize int64)                     {}
+func (NopReporter) ReportSubmitConsume(consume time.Duration)                             {}
+func (NopReporter) ReportJobWait(jobid uint64, hashkey string, duration time.Duration)    {}
+func (NopReporter) ReportJobConsume(jobid uint64, hashkey string, duration time.Duration) {}
+func (NopReporter) ReportJobTimeout(jobid uint64, hashkey string)                         {}
+func (NopReporter) ReportJobOverflow(jobid uint64, policy string)                         {}
+func (NopReporter) ReportKeyCollision(jobid uint64)                                       {}
+func (NopReporter) ReportClassWait(class string, duration time.Duration)                  {}
+
+type reporterHolder struct {
+	Reporter
+}
+
+var reporter atomic.Pointer[reporterHolder]
+
+func init() {
+	SetReporter(nil)
+}
+
+// SetReporter 设置全局的指标上报实现，nil 表示不上报
+func SetReporter(r Reporter) {
+	if r == nil {
+		r = NopReporter{}
+	}
+
+	reporter.Store(&reporterHolder{r})
+}
+
+// GetReporter 获取当前的指标上报实现
+func GetReporter() Reporter {
+	return reporter.Load().Reporter
+}
+
 // ReportJobCount 上报任务数量，工作队列使用率
 func ReportJobCount(jobid uint64, count int64) {
-
+	GetReporter().ReportJobCount(jobid, count)
 }
 
 // ReportPoolSize 上报工作池大小
 func ReportPoolSize(poolSize int64, blockingSize int64) {
-
+	GetReporter().ReportPoolSize(poolSize, blockingSize)
 }
 
-// ReportSubmitConsume 上报任务提交耗时
+// ReportSubmitConsume 上报任务提交耗时，单位纳秒
 func ReportSubmitConsume(consume int64) {
+	GetReporter().ReportSubmitConsume(time.Duration(consume))
+}
 
+// ReportJobWait 任务排队等待时间
+func ReportJobWait(jobid uint64, hashkey string, duration time.Duration) {
+	GetReporter().ReportJobWait(jobid, hashkey, duration)
 }
 
-// ReportJobConsume 任务消费时间
+// ReportJobConsume 任务消费时间，单位纳秒
 func ReportJobConsume(jobid uint64, hashkey string, duration int64) {
-
+	GetReporter().ReportJobConsume(jobid, hashkey, time.Duration(duration))
 }
 
 // ReportJobTimeout 任务超时统计
 func ReportJobTimeout(jobid uint64, hashkey string) {
+	GetReporter().ReportJobTimeout(jobid, hashkey)
+}
+
+// ReportJobOverflow 任务队列溢出统计
+func ReportJobOverflow(jobid uint64, policy string) {
+	GetReporter().ReportJobOverflow(jobid, policy)
+}
+
+// ReportKeyCollision key hash 冲突统计
+func ReportKeyCollision(jobid uint64) {
+	GetReporter().ReportKeyCollision(jobid)
+}
 
+// ReportClassWait 公平调度分类的等待时间
+func ReportClassWait(class string, duration time.Duration) {
+	GetReporter().ReportClassWait(class, duration)
 }
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryReporter(t *testing.T) {
+	reporter := NewMemoryReporter()
+	SetReporter(reporter)
+	defer SetReporter(nil)
+
+	if GetReporter() != reporter {
+		t.Fatalf("expected memory reporter, got %T", GetReporter())
+	}
+
+	ReportJobCount(1, 3)
+	ReportJobCount(1, 5)
+	ReportPoolSize(10, 2)
+	ReportSubmitConsume(int64(time.Millisecond))
+	ReportJobWait(1, "1", time.Millisecond*2)
+	ReportJobConsume(1, "1", int64(time.Millisecond*3))
+	ReportJobTimeout(1, "1")
+	ReportJobOverflow(1, "reject")
+	ReportJobOverflow(2, "reject")
+	ReportKeyCollision(1)
+	ReportClassWait("gold", time.Millisecond*4)
+
+	if count := reporter.JobCount(1); count != 5 {
+		t.Fatalf("expected job count %v, got %v", 5, count)
+	}
+	if poolSize, blockingSize := reporter.PoolSize(); poolSize != 10 || blockingSize != 2 {
+		t.Fatalf("expected pool size %v %v, got %v %v", 10, 2, poolSize, blockingSize)
+	}
+	if submits := reporter.SubmitConsumes(); len(submits) != 1 || submits[0] != time.Millisecond {
+		t.Fatalf("expected submit consumes %v, got %v", []time.Duration{time.Millisecond}, submits)
+	}
+	if waits := reporter.JobWaits(1); len(waits) != 1 || waits[0] != time.Millisecond*2 {
+		t.Fatalf("expected job waits %v, got %v", []time.Duration{time.Millisecond * 2}, waits)
+	}
+	if consumes := reporter.JobConsumes(1); len(consumes) != 1 || consumes[0] != time.Millisecond*3 {
+		t.Fatalf("expected job consumes %v, got %v", []time.Duration{time.Millisecond * 3}, consumes)
+	}
+	if timeouts := reporter.JobTimeouts(1); timeouts != 1 {
+		t.Fatalf("expected timeouts %v, got %v", 1, timeouts)
+	}
+	if overflows := reporter.JobOverflows("reject"); overflows != 2 {
+		t.Fatalf("expected overflows %v, got %v", 2, overflows)
+	}
+	if collisions := reporter.KeyCollisions(1); collisions != 1 {
+		t.Fatalf("expected collisions %v, got %v", 1, collisions)
+	}
+	if waits := reporter.ClassWaits("gold"); len(waits) != 1 || waits[0] != time.Millisecond*4 {
+		t.Fatalf("expected class waits %v, got %v", []time.Duration{time.Millisecond * 4}, waits)
+	}
+
+	// 返回的是副本，修改不影响统计
+	reporter.JobWaits(1)[0] = 0
+	if waits := reporter.JobWaits(1); waits[0] != time.Millisecond*2 {
+		t.Fatalf("expected job waits unchanged, got %v", waits)
+	}
+}
+
+func TestSetReporterNil(t *testing.T) {
+	SetReporter(nil)
+	if _, ok := GetReporter().(NopReporter); !ok {
+		t.Fatalf("expected nop reporter, got %T", GetReporter())
+	}
+
+	// 没有设置时上报不会 panic
+	ReportJobCount(1, 1)
+	ReportSubmitConsume(1)
+	ReportJobConsume(1, "1", 1)
+}
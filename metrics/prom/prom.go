@@ -0,0 +1,158 @@
+package prom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"pipeline/metrics"
+)
+
+// DefaultNamespace 默认的指标名前缀
+const DefaultNamespace = "pipeline"
+
+// Reporter prometheus 指标上报
+// 任务队列的 hash key 数量不可控，指标不区分 key，避免标签基数膨胀
+type Reporter struct {
+	queueDepth   prometheus.Histogram
+	poolRunning  prometheus.Gauge
+	poolWaiting  prometheus.Gauge
+	submitTime   prometheus.Histogram
+	jobWaitTime  prometheus.Histogram
+	jobRunTime   prometheus.Histogram
+	jobTimeouts  prometheus.Counter
+	jobOverflows *prometheus.CounterVec
+	collisions   prometheus.Counter
+	classWait    *prometheus.HistogramVec
+}
+
+var _ metrics.Reporter = (*Reporter)(nil)
+
+// NewReporter 创建 prometheus 指标上报，namespace 为空时使用 DefaultNamespace
+func NewReporter(namespace string) *Reporter {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	return &Reporter{
+		queueDepth: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_queue_depth",
+			Help:      "Number of jobs buffered in a key's queue after each post.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		poolRunning: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pool_running_workers",
+			Help:      "Number of running workers in the consumer pool.",
+		}),
+		poolWaiting: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pool_waiting_submits",
+			Help:      "Number of submits blocked waiting for a free worker.",
+		}),
+		submitTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "submit_duration_seconds",
+			Help:      "Time spent submitting a job queue to the consumer pool.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}),
+		jobWaitTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_wait_duration_seconds",
+			Help:      "Time a job waited in its key's queue before running.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 12),
+		}),
+		jobRunTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_run_duration_seconds",
+			Help:      "Time a job spent running.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 12),
+		}),
+		jobTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_timeouts_total",
+			Help:      "Number of jobs that expired or ran too long.",
+		}),
+		jobOverflows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_overflows_total",
+			Help:      "Number of posts that hit the per-key queue limit, by overflow policy.",
+		}, []string{"policy"}),
+		collisions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "key_collisions_total",
+			Help:      "Number of distinct keys routed to a hash already used by another key.",
+		}),
+		classWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "class_wait_duration_seconds",
+			Help:      "Time a ready job queue waited for a worker turn under fair scheduling, by class.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 12),
+		}, []string{"class"}),
+	}
+}
+
+// Collectors 所有的指标
+func (r *Reporter) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		r.queueDepth,
+		r.poolRunning,
+		r.poolWaiting,
+		r.submitTime,
+		r.jobWaitTime,
+		r.jobRunTime,
+		r.jobTimeouts,
+		r.jobOverflows,
+		r.collisions,
+		r.classWait,
+	}
+}
+
+// Register 注册所有的指标
+func (r *Reporter) Register(reg prometheus.Registerer) error {
+	for _, c := range r.Collectors() {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Reporter) ReportJobCount(jobid uint64, count int64) {
+	r.queueDepth.Observe(float64(count))
+}
+
+func (r *Reporter) ReportPoolSize(poolSize int64, blockingSize int64) {
+	r.poolRunning.Set(float64(poolSize))
+	r.poolWaiting.Set(float64(blockingSize))
+}
+
+func (r *Reporter) ReportSubmitConsume(consume time.Duration) {
+	r.submitTime.Observe(consume.Seconds())
+}
+
+func (r *Reporter) ReportJobWait(jobid uint64, hashkey string, duration time.Duration) {
+	r.jobWaitTime.Observe(duration.Seconds())
+}
+
+func (r *Reporter) ReportJobConsume(jobid uint64, hashkey string, duration time.Duration) {
+	r.jobRunTime.Observe(duration.Seconds())
+}
+
+func (r *Reporter) ReportJobTimeout(jobid uint64, hashkey string) {
+	r.jobTimeouts.Inc()
+}
+
+func (r *Reporter) ReportJobOverflow(jobid uint64, policy string) {
+	r.jobOverflows.WithLabelValues(policy).Inc()
+}
+
+func (r *Reporter) ReportKeyCollision(jobid uint64) {
+	r.collisions.Inc()
+}
+
+func (r *Reporter) ReportClassWait(class string, duration time.Duration) {
+	r.classWait.WithLabelValues(class).Observe(duration.Seconds())
+}
@@ -0,0 +1,41 @@
+package prom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReporter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reporter := NewReporter("")
+	if err := reporter.Register(reg); err != nil {
+		t.Fatalf("register err %v", err)
+	}
+
+	reporter.ReportJobCount(1, 10)
+	reporter.ReportPoolSize(3, 2)
+	reporter.ReportSubmitConsume(time.Millisecond)
+	reporter.ReportJobWait(1, "1", time.Millisecond)
+	reporter.ReportJobConsume(1, "1", time.Millisecond)
+	reporter.ReportJobTimeout(1, "1")
+	reporter.ReportJobOverflow(1, "reject")
+	reporter.ReportJobOverflow(1, "reject")
+
+	if v := testutil.ToFloat64(reporter.poolRunning); v != 3 {
+		t.Fatalf("expected pool running %v, got %v", 3, v)
+	}
+	if v := testutil.ToFloat64(reporter.jobTimeouts); v != 1 {
+		t.Fatalf("expected timeouts %v, got %v", 1, v)
+	}
+	if v := testutil.ToFloat64(reporter.jobOverflows.WithLabelValues("reject")); v != 2 {
+		t.Fatalf("expected overflows %v, got %v", 2, v)
+	}
+
+	count, err := testutil.GatherAndCount(reg, "pipeline_job_run_duration_seconds")
+	if err != nil || count != 1 {
+		t.Fatalf("expected %v run duration metric, got %v err %v", 1, count, err)
+	}
+}
@@ -1,7 +1,11 @@
 package pipeline
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"pipeline/dispatcher"
 	"pipeline/jobs"
@@ -9,10 +13,12 @@ import (
 )
 
 var (
-	// 默认的全局worker queue
-	globalWokerQueue = jobs.NewWorkQueue(jobs.GetDefaultConfig())
+	// 默认的全局实例，包级函数都使用该实例，RelaunchDefaultWorkerQueue 时替换
+	defaultPipeline atomic.Pointer[Pipeline]
+	// 串行重建默认实例
+	relaunchMutex sync.Mutex
 
-	// 默认的全局uint64 pipeline
+	// 默认的全局uint64 pipeline，通过 GlobalWorkerQueueGetter 使用当前的默认实例
 	defaultUint64Pipeline = dispatcher.GetGlobalDispatcher(&serial.Uint64Serializer{})
 
 	// 默认的全局bytes pipeline
@@ -20,32 +26,98 @@ var (
 )
 
 func init() {
+	defaultPipeline.Store(mustNew())
 	jobs.GlobalWorkerQueueGetter = func() jobs.BaseWorkerQueue {
-		return globalWokerQueue
+		return Default().WorkerQueue()
 	}
 }
 
+func mustNew() *Pipeline {
+	p, err := New()
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+// Default 默认的全局实例，RelaunchDefaultWorkerQueue 重建后返回新的实例
+func Default() *Pipeline {
+	return defaultPipeline.Load()
+}
+
 // RelaunchWorkerQueue 重置默认队列
+// 运行中的默认队列在线调整配置，不丢弃已经投递的任务，不支持在线调整的配置（ProviderShards、ExactKeyRouting 等）返回错误
+// 默认队列已经通过 ShutdownDefaultWorkerQueue 停止时，使用新的配置重新创建
+//
+// Deprecated: 使用 ReconfigureDefaultWorkerQueue 在线调整配置
 func RelaunchDefaultWorkerQueue(cfg *jobs.PipelineConfig) error {
-	if cfg == nil ||
-		cfg.MaxWorkerQueueCount <= 0 ||
-		cfg.MaxJobsPerWorker <= 0 {
-		return fmt.Errorf("config is invalid %+v ", cfg)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	relaunchMutex.Lock()
+	defer relaunchMutex.Unlock()
+
+	// 停止的队列已经不再接受投递，没有需要保留的任务
+	if err := Default().Reconfigure(cfg); !errors.Is(err, jobs.ErrStopped) {
+		return err
 	}
 
-	if globalWokerQueue != nil {
-		globalWokerQueue.Stop()
+	p, err := New(WithConfig(cfg))
+	if err != nil {
+		return err
 	}
 
-	globalWokerQueue = jobs.NewWorkQueue(cfg)
+	defaultPipeline.Store(p)
 	return nil
 }
 
+// ReconfigureDefaultWorkerQueue 在线调整默认队列的配置，不丢弃已经投递的任务
+func ReconfigureDefaultWorkerQueue(cfg *jobs.PipelineConfig) error {
+	return Default().Reconfigure(cfg)
+}
+
+// ShutdownDefaultWorkerQueue 停止默认队列，等待所有任务执行完成，之后可以通过 RelaunchDefaultWorkerQueue 重新启动
+func ShutdownDefaultWorkerQueue(ctx context.Context) (*jobs.ShutdownReport, error) {
+	return Default().Shutdown(ctx)
+}
+
+// GetStuckKeys 获取默认队列中执行时间超过阈值的队列
+func GetStuckKeys() []jobs.StuckKey {
+	return Default().StuckKeys()
+}
+
+// GetSnapshot 获取默认队列的快照
+func GetSnapshot() *jobs.Snapshot {
+	return Default().Snapshot()
+}
+
 // PostUint64 投递任务
 func PostUint64(key uint64, f jobs.Job) error {
 	return defaultUint64Pipeline.Post(key, f)
 }
 
+// PostContextUint64 投递携带上下文的任务
+func PostContextUint64(ctx context.Context, key uint64, f jobs.ContextJob) error {
+	return defaultUint64Pipeline.PostContext(ctx, key, f)
+}
+
+// PostAfterUint64 延迟投递任务
+func PostAfterUint64(key uint64, delay time.Duration, f jobs.Job) (*jobs.Timer, error) {
+	return defaultUint64Pipeline.PostAfter(key, delay, f)
+}
+
+// PostEveryUint64 周期投递任务
+func PostEveryUint64(key uint64, interval time.Duration, f jobs.Job) (*jobs.Timer, error) {
+	return defaultUint64Pipeline.PostEvery(key, interval, f)
+}
+
+// PostAndWaitUint64 投递任务并等待执行完成
+func PostAndWaitUint64(key uint64, f jobs.Job) error {
+	return defaultUint64Pipeline.PostAndWait(key, f)
+}
+
 // GetJobsBuffLenUint64 获取当前jobs缓冲区长度
 func GetJobsBuffLenUint64(key uint64) (int, error) {
 	return defaultUint64Pipeline.GetJobsBuffLen(key)
@@ -61,6 +133,26 @@ func PostBytes(key []byte, f jobs.Job) error {
 	return defaultBytesPipeline.Post(key, f)
 }
 
+// PostContextBytes 投递携带上下文的任务
+func PostContextBytes(ctx context.Context, key []byte, f jobs.ContextJob) error {
+	return defaultBytesPipeline.PostContext(ctx, key, f)
+}
+
+// PostAfterBytes 延迟投递任务
+func PostAfterBytes(key []byte, delay time.Duration, f jobs.Job) (*jobs.Timer, error) {
+	return defaultBytesPipeline.PostAfter(key, delay, f)
+}
+
+// PostEveryBytes 周期投递任务
+func PostEveryBytes(key []byte, interval time.Duration, f jobs.Job) (*jobs.Timer, error) {
+	return defaultBytesPipeline.PostEvery(key, interval, f)
+}
+
+// PostAndWaitBytes 投递任务并等待执行完成
+func PostAndWaitBytes(key []byte, f jobs.Job) error {
+	return defaultBytesPipeline.PostAndWait(key, f)
+}
+
 // GetJobsBuffLenBytes 获取当前jobs缓冲区长度
 func GetJobsBuffLenBytes(key []byte) (int, error) {
 	return defaultBytesPipeline.GetJobsBuffLen(key)
@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pipeline/jobs"
+	"pipeline/serial"
+)
+
+func TestNew(t *testing.T) {
+	cfg := jobs.GetDefaultConfig()
+	cfg.MaxWorkerQueueCount = 10
+	first, err := New(WithConfig(cfg))
+	if err != nil {
+		t.Fatalf("new pipeline error %v", err)
+	}
+	second, err := New()
+	if err != nil {
+		t.Fatalf("new pipeline error %v", err)
+	}
+
+	result := 0
+	d := NewDispatcher(first, &serial.DefaultSerializer[string]{})
+	d.Post("1", func() { result++ })
+	if err := first.Uint64().PostAndWait(1, func() { result++ }); err != nil {
+		t.Fatalf("post and wait error %v", err)
+	}
+	d.PostAndWait("1", func() {})
+	if result != 2 {
+		t.Fatalf("expected %v, got %v", 2, result)
+	}
+
+	// 实例之间互不影响
+	if _, err := first.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error %v", err)
+	}
+	if err := first.Bytes().Post([]byte("1"), func() {}); err != jobs.ErrStopped {
+		t.Fatalf("expected %v, got %v", jobs.ErrStopped, err)
+	}
+	if err := second.Bytes().PostAndWait([]byte("1"), func() {}); err != nil {
+		t.Fatalf("expected other pipeline running, got %v", err)
+	}
+	if err := PostAndWaitUint64(1, func() {}); err != nil {
+		t.Fatalf("expected default pipeline running, got %v", err)
+	}
+
+	if _, err := New(WithConfig(&jobs.PipelineConfig{})); err == nil {
+		t.Fatalf("expected invalid config error")
+	}
+}
+
+func TestRelaunchDefaultWorkerQueue(t *testing.T) {
+	defer RelaunchDefaultWorkerQueue(jobs.GetDefaultConfig())
+
+	// 运行中的队列不支持在线调整的配置返回错误，已经投递的任务不会丢弃
+	block := make(chan struct{})
+	executed := make(chan struct{})
+	PostUint64(1, func() { <-block })
+	PostUint64(1, func() { close(executed) })
+
+	cfg := jobs.GetDefaultConfig()
+	cfg.ExactKeyRouting = !cfg.ExactKeyRouting
+	if err := RelaunchDefaultWorkerQueue(cfg); err == nil {
+		t.Fatalf("expected exact key routing change error")
+	}
+	close(block)
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		t.Fatalf("expected queued job executed after relaunch")
+	}
+
+	// 停止后重新启动
+	if _, err := ShutdownDefaultWorkerQueue(context.Background()); err != nil {
+		t.Fatalf("shutdown error %v", err)
+	}
+	if err := PostUint64(1, func() {}); err != jobs.ErrStopped {
+		t.Fatalf("expected %v, got %v", jobs.ErrStopped, err)
+	}
+	if err := RelaunchDefaultWorkerQueue(jobs.GetDefaultConfig()); err != nil {
+		t.Fatalf("relaunch error %v", err)
+	}
+	if err := PostAndWaitUint64(1, func() {}); err != nil {
+		t.Fatalf("expected relaunched pipeline running, got %v", err)
+	}
+
+	if err := RelaunchDefaultWorkerQueue(&jobs.PipelineConfig{}); err == nil {
+		t.Fatalf("expected invalid config error")
+	}
+}
@@ -2,6 +2,7 @@ package serial
 
 import (
 	"math"
+	"strings"
 	"testing"
 )
 
@@ -378,3 +379,19 @@ func TestSerialString(t *testing.T) {
 		})
 	}
 }
+
+func TestGoroutineStack(t *testing.T) {
+	id := GoroutineID()
+	if id == 0 {
+		t.Fatalf("goroutine id should not be 0")
+	}
+
+	stack := GoroutineStack(id)
+	if !strings.Contains(string(stack), "TestGoroutineStack") {
+		t.Fatalf("expected stack of current goroutine, got %s", stack)
+	}
+
+	if stack := GoroutineStack(math.MaxUint64); stack != nil {
+		t.Fatalf("expected nil stack, got %s", stack)
+	}
+}
@@ -1,27 +1,82 @@
 package serial
 
 import (
-	"log"
+	"bytes"
+	"runtime"
 	"runtime/debug"
+	"strconv"
+
+	"pipeline/logger"
 )
 
+// RecoverFromPanic 执行 fn，panic 后记录日志并重新启动
 func RecoverFromPanic(fn func()) {
+	RecoverFromPanicWith(logger.Default(), fn)
+}
+
+// RecoverFromPanicWith 使用指定的日志记录 panic
+func RecoverFromPanicWith(log logger.Logger, fn func()) {
 	defer func() {
 		if err := recover(); err != nil {
-			log.Printf("panic: %+v\nStack Trace:\n%s", err, debug.Stack())
-			go RecoverFromPanic(fn)
+			log.Error("panic recovered, restarting", "recovered", err, "stack", string(debug.Stack()))
+			go RecoverFromPanicWith(log, fn)
 		}
 	}()
 
 	fn()
 }
 
+// RecoverGo 执行 fn，panic 后记录日志
 func RecoverGo(fn func()) {
+	RecoverGoWith(logger.Default(), fn)
+}
+
+// RecoverGoWith 使用指定的日志记录 panic
+func RecoverGoWith(log logger.Logger, fn func()) {
 	defer func() {
 		if err := recover(); err != nil {
-			log.Printf("panic: %+v\nStack Trace:\n%s", err, debug.Stack())
+			log.Error("panic recovered", "recovered", err, "stack", string(debug.Stack()))
 		}
 	}()
 
 	fn()
 }
+
+// GoroutineID 获取当前协程id，解析自 runtime.Stack 的首行 "goroutine N [running]:"
+func GoroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	stack := bytes.TrimPrefix(buf[:n], []byte("goroutine "))
+	if i := bytes.IndexByte(stack, ' '); i > 0 {
+		stack = stack[:i]
+	}
+
+	id, err := strconv.ParseUint(string(stack), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return id
+}
+
+// GoroutineStack 获取指定协程的调用栈，协程不存在时返回 nil
+func GoroutineStack(id uint64) []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+
+	prefix := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, prefix) {
+			return stack
+		}
+	}
+
+	return nil
+}